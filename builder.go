@@ -0,0 +1,224 @@
+package yq
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+var (
+	paramNameRe   = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+	placeholderRe = regexp.MustCompile(`\{\{\s*([A-Za-z_][A-Za-z0-9_]*)\s*\}\}`)
+)
+
+// QueryBuilder assembles YQL query text with named parameters. Parameters are
+// bound as YQL named expressions ("$name = <literal>;") placed before the
+// query text, so values are escaped by the builder instead of being
+// concatenated into the query by hand.
+type QueryBuilder struct {
+	text   strings.Builder
+	names  []string
+	params map[string]interface{}
+	err    error
+}
+
+// NewQueryBuilder returns an empty QueryBuilder.
+func NewQueryBuilder() *QueryBuilder {
+	return &QueryBuilder{params: make(map[string]interface{})}
+}
+
+// Write appends raw YQL text.
+func (b *QueryBuilder) Write(text string) *QueryBuilder {
+	b.text.WriteString(text)
+	return b
+}
+
+// Identifier appends name as a quoted YQL identifier.
+func (b *QueryBuilder) Identifier(name string) *QueryBuilder {
+	b.text.WriteString(quoteIdentifier(name))
+	return b
+}
+
+// Param appends a reference to the named parameter and binds it to value.
+func (b *QueryBuilder) Param(name string, value interface{}) *QueryBuilder {
+	b.Bind(name, value)
+	b.text.WriteString("$" + name)
+	return b
+}
+
+// Bind binds the named parameter to value without referencing it. Binding a
+// name again replaces its value.
+func (b *QueryBuilder) Bind(name string, value interface{}) *QueryBuilder {
+	if !paramNameRe.MatchString(name) {
+		if b.err == nil {
+			b.err = fmt.Errorf("invalid parameter name %q", name)
+		}
+		return b
+	}
+	if _, ok := b.params[name]; !ok {
+		b.names = append(b.names, name)
+	}
+	b.params[name] = value
+	return b
+}
+
+// Params returns the bound parameters.
+func (b *QueryBuilder) Params() map[string]interface{} {
+	params := make(map[string]interface{}, len(b.params))
+	for k, v := range b.params {
+		params[k] = v
+	}
+	return params
+}
+
+// Build returns the query text with parameter bindings prepended.
+func (b *QueryBuilder) Build() (string, error) {
+	if b.err != nil {
+		return "", b.err
+	}
+
+	var sb strings.Builder
+	for _, name := range b.names {
+		literal, err := yqlLiteral(b.params[name])
+		if err != nil {
+			return "", fmt.Errorf("parameter %s: %w", name, err)
+		}
+		sb.WriteString("$" + name + " = " + literal + ";\n")
+	}
+	sb.WriteString(b.text.String())
+	return sb.String(), nil
+}
+
+func quoteIdentifier(name string) string {
+	name = strings.ReplaceAll(name, `\`, `\\`)
+	name = strings.ReplaceAll(name, "`", "\\`")
+	return "`" + name + "`"
+}
+
+func quoteString(s string) string {
+	var sb strings.Builder
+	sb.WriteByte('\'')
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; c {
+		case '\\':
+			sb.WriteString(`\\`)
+		case '\'':
+			sb.WriteString(`\'`)
+		case '\n':
+			sb.WriteString(`\n`)
+		case '\r':
+			sb.WriteString(`\r`)
+		case '\t':
+			sb.WriteString(`\t`)
+		default:
+			if c < 0x20 || c == 0x7f {
+				fmt.Fprintf(&sb, `\x%02x`, c)
+			} else {
+				sb.WriteByte(c)
+			}
+		}
+	}
+	sb.WriteByte('\'')
+	return sb.String()
+}
+
+func yqlLiteral(value interface{}) (string, error) {
+	switch v := value.(type) {
+	case nil:
+		return "NULL", nil
+	case string:
+		if utf8.ValidString(v) {
+			return quoteString(v) + "u", nil
+		}
+		return quoteString(v), nil
+	case []byte:
+		return quoteString(string(v)), nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	case int8:
+		return strconv.FormatInt(int64(v), 10) + "t", nil
+	case int16:
+		return strconv.FormatInt(int64(v), 10) + "s", nil
+	case int32:
+		return strconv.FormatInt(int64(v), 10), nil
+	case int:
+		return strconv.FormatInt(int64(v), 10) + "l", nil
+	case int64:
+		return strconv.FormatInt(v, 10) + "l", nil
+	case uint8:
+		return strconv.FormatUint(uint64(v), 10) + "ut", nil
+	case uint16:
+		return strconv.FormatUint(uint64(v), 10) + "us", nil
+	case uint32:
+		return strconv.FormatUint(uint64(v), 10) + "u", nil
+	case uint:
+		return strconv.FormatUint(uint64(v), 10) + "ul", nil
+	case uint64:
+		return strconv.FormatUint(v, 10) + "ul", nil
+	case float32:
+		if math.IsNaN(float64(v)) || math.IsInf(float64(v), 0) {
+			return "", fmt.Errorf("unsupported float value %v", v)
+		}
+		return floatLiteral(strconv.FormatFloat(float64(v), 'g', -1, 32)) + "f", nil
+	case float64:
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			return "", fmt.Errorf("unsupported float value %v", v)
+		}
+		return floatLiteral(strconv.FormatFloat(v, 'g', -1, 64)), nil
+	case time.Time:
+		return `Timestamp("` + v.UTC().Format("2006-01-02T15:04:05.000000Z") + `")`, nil
+	default:
+		return "", fmt.Errorf("unsupported parameter type %T", value)
+	}
+}
+
+func floatLiteral(s string) string {
+	if strings.ContainsAny(s, ".eE") {
+		return s
+	}
+	return s + ".0"
+}
+
+// RenderQueryTemplate replaces {{name}} placeholders in template with the
+// matching vars, quoted as YQL identifiers. It is meant for table and column
+// names; pass values through QueryBuilder parameters instead. Every
+// placeholder must have a variable.
+func RenderQueryTemplate(template string, vars map[string]string) (string, error) {
+	missing := map[string]bool{}
+	text := placeholderRe.ReplaceAllStringFunc(template, func(match string) string {
+		name := placeholderRe.FindStringSubmatch(match)[1]
+		value, ok := vars[name]
+		if !ok {
+			missing[name] = true
+			return match
+		}
+		return quoteIdentifier(value)
+	})
+
+	if len(missing) > 0 {
+		names := make([]string, 0, len(missing))
+		for name := range missing {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return "", fmt.Errorf("missing template variables: %s", strings.Join(names, ", "))
+	}
+	return text, nil
+}
+
+// RunQueryTemplate renders template with vars (see RenderQueryTemplate) into
+// the text of req and runs it like RunQuery.
+func (c *Client) RunQueryTemplate(ctx context.Context, template string, vars map[string]string, req CreateQueryRequest, policy WaitPolicy) (string, int, error) {
+	text, err := RenderQueryTemplate(template, vars)
+	if err != nil {
+		return "", 0, err
+	}
+	req.Text = text
+	return c.RunQuery(ctx, req, "", policy.ExecutionTimeout, policy.StopOnTimeout)
+}
@@ -4,11 +4,15 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"net/url"
+	"os"
 	"strconv"
+	"strings"
+	"sync"
 	"time"
 )
 
@@ -20,6 +24,7 @@ const (
 	DefaultEndpoint    = "https://api.yandex-query.cloud.yandex.net"
 	DefaultWebBaseURL  = "https://yq.cloud.yandex.ru"
 	DefaultTokenPrefix = "Bearer "
+	StopTimeout        = 10 * time.Second
 )
 
 const (
@@ -28,12 +33,142 @@ const (
 )
 
 type ClientConfig struct {
-	Token       string
-	Project     string
-	UserAgent   string
-	Endpoint    string
-	WebBaseURL  string
+	Token      string
+	Project    string
+	UserAgent  string
+	Endpoint   string
+	WebBaseURL string
+
+	// TokenPrefix precedes the token in the Authorization header. It defaults
+	// to DefaultTokenPrefix. Trailing spaces and tabs are replaced by a single
+	// space, so "Bearer", "Bearer  " and "Bearer\t" all send "Bearer <token>".
 	TokenPrefix string
+
+	// ImpersonationHeader and ImpersonationValue set a subject header sent
+	// with every request, for platforms acting on behalf of sub-accounts.
+	// WithImpersonation overrides them per call.
+	ImpersonationHeader string
+	ImpersonationValue  string
+
+	// OpenAPISpecAuth makes GetOpenAPISpec send the Authorization header, for
+	// deployments that protect the resources path.
+	OpenAPISpecAuth bool
+	// CacheOpenAPISpec makes GetOpenAPISpec keep the spec in memory after the
+	// first successful fetch.
+	CacheOpenAPISpec bool
+
+	// Signer, if set, signs every outgoing request, including each retry.
+	Signer RequestSigner
+
+	// PollInterval, if set, decides how long the wait methods sleep between
+	// status polls, based on how long they have been waiting.
+	PollInterval PollIntervalFunc
+
+	// RetryPolicy controls retries of failed requests. Nil means DefaultRetryPolicy.
+	RetryPolicy *RetryPolicy
+
+	// PollRetryPolicy controls retries of the status polls made while waiting
+	// for a query. Nil means no retries: a failed poll is instead retried at
+	// the next poll, up to MaxPollFailures consecutive failures.
+	PollRetryPolicy *RetryPolicy
+	// PollTimeout limits each status poll made while waiting. Zero means no limit.
+	PollTimeout time.Duration
+
+	// MaxResponseBytes limits the size of response bodies the client reads.
+	// Reading past it fails with ErrResponseTooLarge. Zero means no limit.
+	MaxResponseBytes int64
+
+	// DedupeCreates coalesces concurrent CreateQuery calls with the same
+	// idempotency key into one request whose result they all share. The
+	// result is reused for DedupeTTL (DefaultDedupeTTL if zero).
+	DedupeCreates bool
+	DedupeTTL     time.Duration
+
+	// TypeMapping overrides the Go representation of column types in the
+	// results returned by the client. See WithTypeMapping.
+	TypeMapping TypeMapping
+
+	// MaxFetchDuration limits how long GetQueryResultSet keeps fetching pages
+	// of a result set, independently of the context. When it is exceeded the
+	// call fails with ErrFetchDurationExceeded, or, if TruncateOnMaxFetchDuration
+	// is set, returns the rows fetched so far; Results.Truncated of the
+	// result sets returned as *Results tells them apart. Zero means no limit.
+	MaxFetchDuration           time.Duration
+	TruncateOnMaxFetchDuration bool
+
+	// RequireResultSets makes waiting for a query fail with ErrNoResultSets
+	// when it completes without a result_sets field, instead of counting that,
+	// as DDL queries do, as zero result sets.
+	RequireResultSets bool
+
+	// Debug dumps every request and response, including bodies, to
+	// DebugWriter (os.Stderr if nil). The Authorization header is redacted.
+	Debug       bool
+	DebugWriter io.Writer
+}
+
+// PollIntervalFunc returns the delay before the next status poll given the
+// time elapsed since waiting started.
+type PollIntervalFunc func(elapsed time.Duration) time.Duration
+
+// RequestSigner adds signature headers to a request before it is sent, for
+// gateways that require signed requests.
+type RequestSigner interface {
+	Sign(req *http.Request) error
+}
+
+// ErrQueryNotCompleted is returned when an operation requires a completed query.
+var ErrQueryNotCompleted = errors.New("query is not completed")
+
+// ErrClientClosed is returned by calls made after Close, and by calls that were
+// in flight when the client was closed.
+var ErrClientClosed = errors.New("client closed")
+
+// ErrResponseTooLarge is returned when a response body exceeds MaxResponseBytes.
+var ErrResponseTooLarge = errors.New("response body too large")
+
+// ErrFetchDurationExceeded is returned when fetching a result set takes longer
+// than MaxFetchDuration.
+var ErrFetchDurationExceeded = errors.New("result set fetch duration exceeded")
+
+// ErrNoResultSets is returned for a completed query without result_sets when
+// RequireResultSets is set.
+var ErrNoResultSets = errors.New("query has no result_sets")
+
+// ErrExecutionTimeout is returned when a query doesn't complete within the
+// execution timeout passed to the wait methods.
+var ErrExecutionTimeout = errors.New("execution timeout")
+
+// WaitError is returned when waiting for a query is cut short by the execution
+// timeout or by the context. It carries the last status observed, which is
+// empty if no status was fetched yet.
+type WaitError struct {
+	QueryID    string
+	LastStatus QueryStatus
+	Err        error
+}
+
+func (e *WaitError) Error() string {
+	if e.Err == ErrExecutionTimeout {
+		return fmt.Sprintf("query %s execution timeout (last status: %s)", e.QueryID, e.LastStatus)
+	}
+	return fmt.Sprintf("query %s wait interrupted (last status: %s): %v", e.QueryID, e.LastStatus, e.Err)
+}
+
+func (e *WaitError) Unwrap() error {
+	return e.Err
+}
+
+// StatusMismatchError is returned by WaitForStatus when the query finishes
+// with a status other than the one waited for.
+type StatusMismatchError struct {
+	QueryID string
+	Status  QueryStatus
+	Target  QueryStatus
+}
+
+func (e *StatusMismatchError) Error() string {
+	return fmt.Sprintf("query %s finished with status %s instead of %s", e.QueryID, e.Status, e.Target)
 }
 
 type YQError struct {
@@ -41,51 +176,299 @@ type YQError struct {
 	Status  string
 	Msg     string
 	Details interface{}
+	// RequestID is the x-request-id the failed request was sent with, if any.
+	RequestID string
+	// StatusCode is the HTTP status code of the response.
+	StatusCode int
+
+	retryAfter string
+}
+
+// RetryAfter returns how long the server asked to wait before retrying, as
+// given by the Retry-After header of the response, typically with a 429 or
+// 503. It reports false if the header is missing or malformed.
+func (e *YQError) RetryAfter() (time.Duration, bool) {
+	if e.retryAfter == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(e.retryAfter); err == nil {
+		if seconds < 0 {
+			return 0, false
+		}
+		return time.Duration(seconds) * time.Second, true
+	}
+	if t, err := http.ParseTime(e.retryAfter); err == nil {
+		d := time.Until(t)
+		if d < 0 {
+			d = 0
+		}
+		return d, true
+	}
+	return 0, false
 }
 
 func (e *YQError) Error() string {
+	if e.RequestID != "" {
+		return fmt.Sprintf("%s (Status: %s, Msg: %s, RequestID: %s)", e.Message, e.Status, e.Msg, e.RequestID)
+	}
 	return fmt.Sprintf("%s (Status: %s, Msg: %s)", e.Message, e.Status, e.Msg)
 }
 
 // Client is a YQ HTTP API client.
 type Client struct {
-	config ClientConfig
-	client *http.Client
+	config    ClientConfig
+	defaulted configDefaults
+	client    *http.Client
+
+	baseCtx context.Context
+	close   context.CancelFunc
+
+	creates createDedupe
+
+	// now returns the current time; it is replaceable for testing.
+	now func() time.Time
+
+	debug  *debugLog
+	logger Logger
+
+	specMu sync.Mutex
+	spec   string
 }
 
-// NewClient creates a new YQ HTTP API client.
-func NewClient(config ClientConfig) *Client {
+// NewClient creates a new YQ HTTP API client. Options are applied after the
+// config defaults.
+func NewClient(config ClientConfig, opts ...Option) *Client {
+	var defaulted configDefaults
 	if config.UserAgent == "" {
 		config.UserAgent = DefaultUserAgent
+		defaulted.userAgent = true
 	}
 	if config.Endpoint == "" {
 		config.Endpoint = DefaultEndpoint
+		defaulted.endpoint = true
 	}
 	if config.WebBaseURL == "" {
 		config.WebBaseURL = DefaultWebBaseURL
+		defaulted.webBaseURL = true
 	}
 	if config.TokenPrefix == "" {
 		config.TokenPrefix = DefaultTokenPrefix
+		defaulted.tokenPrefix = true
+	}
+	config.TokenPrefix = normalizeTokenPrefix(config.TokenPrefix)
+	config.Endpoint = strings.TrimRight(config.Endpoint, "/")
+	config.WebBaseURL = strings.TrimRight(config.WebBaseURL, "/")
+
+	baseCtx, cancel := context.WithCancel(context.Background())
+
+	c := &Client{
+		config:    config,
+		defaulted: defaulted,
+		client:    &http.Client{},
+		baseCtx:   baseCtx,
+		close:     cancel,
+		now:       time.Now,
+	}
+	if config.Debug {
+		c.debug = &debugLog{w: config.DebugWriter}
+		if c.debug.w == nil {
+			c.debug.w = os.Stderr
+		}
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// NewClientFromEnv creates a client configured from the YQ_TOKEN, YQ_PROJECT,
+// YQ_ENDPOINT, YQ_WEB_BASE_URL, YQ_USER_AGENT and YQ_TOKEN_PREFIX environment
+// variables. YQ_TOKEN and YQ_PROJECT are required; the rest default as in
+// NewClient. YQ_DEBUG=true enables Debug.
+func NewClientFromEnv() (*Client, error) {
+	config := ClientConfig{
+		Token:       os.Getenv("YQ_TOKEN"),
+		Project:     os.Getenv("YQ_PROJECT"),
+		Endpoint:    os.Getenv("YQ_ENDPOINT"),
+		WebBaseURL:  os.Getenv("YQ_WEB_BASE_URL"),
+		UserAgent:   os.Getenv("YQ_USER_AGENT"),
+		TokenPrefix: os.Getenv("YQ_TOKEN_PREFIX"),
+	}
+	if debug := os.Getenv("YQ_DEBUG"); debug != "" {
+		var err error
+		if config.Debug, err = strconv.ParseBool(debug); err != nil {
+			return nil, fmt.Errorf("invalid YQ_DEBUG: %w", err)
+		}
+	}
+
+	var missing []string
+	if config.Token == "" {
+		missing = append(missing, "YQ_TOKEN")
+	}
+	if config.Project == "" {
+		missing = append(missing, "YQ_PROJECT")
+	}
+	if len(missing) > 0 {
+		return nil, fmt.Errorf("missing required environment variables: %s", strings.Join(missing, ", "))
+	}
+
+	return NewClient(config), nil
+}
+
+// resultsOptions returns the options for Results built by the client.
+func (c *Client) resultsOptions() []ResultsOption {
+	if c.config.TypeMapping == nil {
+		return nil
+	}
+	return []ResultsOption{WithTypeMapping(c.config.TypeMapping)}
+}
+
+// normalizeTokenPrefix makes sure a token prefix such as "Bearer" is separated
+// from the token by exactly one space.
+func normalizeTokenPrefix(prefix string) string {
+	if prefix == "" {
+		return ""
+	}
+	return strings.TrimRight(prefix, " \t") + " "
+}
+
+// Close aborts all in-flight requests of the client. After Close, every call
+// that sends a request fails with ErrClientClosed.
+func (c *Client) Close() error {
+	c.close()
+	return nil
+}
+
+// mergeContext returns a context that is done when either ctx is done or the
+// client is closed.
+func (c *Client) mergeContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(ctx)
+	go func() {
+		select {
+		case <-c.baseCtx.Done():
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	return ctx, cancel
+}
+
+// cancelOnClose releases the request context once the response body is closed.
+type cancelOnClose struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (b *cancelOnClose) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel()
+	return err
+}
+
+// Endpoint returns the API endpoint the client sends requests to.
+func (c *Client) Endpoint() string {
+	return c.config.Endpoint
+}
+
+// Project returns the project (folder) ID the client works with.
+func (c *Client) Project() string {
+	return c.config.Project
+}
+
+// WebBaseURL returns the base URL used for web interface links.
+func (c *Client) WebBaseURL() string {
+	return c.config.WebBaseURL
+}
+
+// UserAgent returns the User-Agent the client sends.
+func (c *Client) UserAgent() string {
+	return c.config.UserAgent
+}
+
+// configDefaults records which config fields NewClient filled with defaults.
+type configDefaults struct {
+	endpoint    bool
+	webBaseURL  bool
+	userAgent   bool
+	tokenPrefix bool
+}
+
+// ConfigSummary describes the effective configuration of a client for
+// diagnostics. The *Default fields tell whether a value is a default rather
+// than explicitly configured. The token itself is never included.
+type ConfigSummary struct {
+	Endpoint           string
+	EndpointDefault    bool
+	WebBaseURL         string
+	WebBaseURLDefault  bool
+	UserAgent          string
+	UserAgentDefault   bool
+	TokenPrefix        string
+	TokenPrefixDefault bool
+	Project            string
+	TokenSet           bool
+}
+
+func (s ConfigSummary) String() string {
+	source := func(defaulted bool) string {
+		if defaulted {
+			return "default"
+		}
+		return "explicit"
+	}
+	token := "not set"
+	if s.TokenSet {
+		token = "set (redacted)"
 	}
+	return fmt.Sprintf("endpoint=%s (%s), web_base_url=%s (%s), user_agent=%q (%s), token_prefix=%q (%s), project=%s, token=%s",
+		s.Endpoint, source(s.EndpointDefault),
+		s.WebBaseURL, source(s.WebBaseURLDefault),
+		s.UserAgent, source(s.UserAgentDefault),
+		s.TokenPrefix, source(s.TokenPrefixDefault),
+		s.Project, token)
+}
 
-	return &Client{
-		config: config,
-		client: &http.Client{},
+// ConfigSummary returns the effective configuration of the client.
+func (c *Client) ConfigSummary() ConfigSummary {
+	return ConfigSummary{
+		Endpoint:           c.config.Endpoint,
+		EndpointDefault:    c.defaulted.endpoint,
+		WebBaseURL:         c.config.WebBaseURL,
+		WebBaseURLDefault:  c.defaulted.webBaseURL,
+		UserAgent:          c.config.UserAgent,
+		UserAgentDefault:   c.defaulted.userAgent,
+		TokenPrefix:        c.config.TokenPrefix,
+		TokenPrefixDefault: c.defaulted.tokenPrefix,
+		Project:            c.config.Project,
+		TokenSet:           c.config.Token != "",
 	}
 }
 
-func (c *Client) buildHeaders(idempotencyKey, requestID string) http.Header {
+func (c *Client) buildHeaders(ctx context.Context, idempotencyKey, requestID string) http.Header {
 	headers := http.Header{}
-	headers.Set("Authorization", c.config.TokenPrefix+c.config.Token)
+	headers.Set("Accept", "application/json")
+	if auth, ok := authFromContext(ctx); ok {
+		headers.Set("Authorization", normalizeTokenPrefix(auth.prefix)+auth.token)
+	} else {
+		headers.Set("Authorization", c.config.TokenPrefix+c.config.Token)
+	}
 	if idempotencyKey != "" {
 		headers.Set("Idempotency-Key", idempotencyKey)
 	}
+	if requestID == "" {
+		requestID = correlationIDFromContext(ctx)
+	}
 	if requestID != "" {
 		headers.Set("x-request-id", requestID)
 	}
 	if c.config.UserAgent != "" {
 		headers.Set("User-Agent", c.config.UserAgent)
 	}
+	if imp, ok := impersonationFromContext(ctx); ok {
+		headers.Set(imp.header, imp.value)
+	} else if c.config.ImpersonationHeader != "" && c.config.ImpersonationValue != "" {
+		headers.Set(c.config.ImpersonationHeader, c.config.ImpersonationValue)
+	}
 	return headers
 }
 
@@ -114,77 +497,229 @@ func (c *Client) composeWebURL(path string) string {
 }
 
 func (c *Client) doRequest(ctx context.Context, method, url string, headers http.Header, body io.Reader) (*http.Response, error) {
+	if c.baseCtx.Err() != nil {
+		return nil, ErrClientClosed
+	}
+
+	ctx, cancel := c.mergeContext(ctx)
+	resp, err := c.doRequestAttempts(ctx, method, url, headers, body)
+	if err != nil {
+		cancel()
+		if c.baseCtx.Err() != nil {
+			return nil, ErrClientClosed
+		}
+		return nil, err
+	}
+
+	resp.Body = &cancelOnClose{ReadCloser: resp.Body, cancel: cancel}
+	return resp, nil
+}
+
+func (c *Client) doRequestAttempts(ctx context.Context, method, url string, headers http.Header, body io.Reader) (*http.Response, error) {
 	var resp *http.Response
 	var err error
 
-	for i := 0; i <= MaxRetryForSession; i++ {
-		req, err := http.NewRequestWithContext(ctx, method, url, body)
+	policy := DefaultRetryPolicy()
+	if c.config.RetryPolicy != nil {
+		policy = *c.config.RetryPolicy
+	}
+	if p, ok := retryPolicyFromContext(ctx); ok {
+		policy = p
+	}
+
+	// The body is buffered so that every attempt, and the signer, sees all
+	// of it.
+	var payload []byte
+	if body != nil {
+		if payload, err = io.ReadAll(body); err != nil {
+			return nil, err
+		}
+	}
+
+	for i := 0; i <= policy.MaxRetries; i++ {
+		var attemptBody io.Reader
+		if body != nil {
+			attemptBody = bytes.NewReader(payload)
+		}
+
+		var req *http.Request
+		req, err = http.NewRequestWithContext(ctx, method, url, attemptBody)
 		if err != nil {
 			return nil, err
 		}
 
-		req.Header = headers
+		req.Header = headers.Clone()
+		if body != nil && req.Header.Get("Content-Type") == "" {
+			req.Header.Set("Content-Type", "application/json")
+		}
+		if c.config.Signer != nil {
+			if err := c.config.Signer.Sign(req); err != nil {
+				return nil, err
+			}
+		}
+
+		if c.debug != nil {
+			c.debugRequest(req, payload)
+		}
 
+		attemptStart := time.Now()
 		resp, err = c.client.Do(req)
+		c.logAttempt(ctx, req, resp, err, time.Since(attemptStart))
 		if err == nil {
+			if c.config.MaxResponseBytes > 0 {
+				resp.Body = &limitedBody{ReadCloser: resp.Body, remaining: c.config.MaxResponseBytes}
+			}
+			if c.debug != nil {
+				c.debugResponse(req, resp)
+			}
 			return resp, nil
 		}
+		if c.debug != nil {
+			c.debugError(req, err)
+		}
+
+		if i == policy.MaxRetries {
+			break
+		}
 
 		select {
 		case <-ctx.Done():
 			return nil, ctx.Err()
-		case <-time.After(TimeBetweenRetries * time.Duration(i+1)):
-			// Exponential backoff
+		case <-time.After(policy.Delay(i)):
 		}
 	}
 
 	return nil, err
 }
 
-func (c *Client) validateHTTPError(resp *http.Response, expectedCode int) error {
-	if resp.StatusCode != expectedCode {
-		var body map[string]interface{}
-		if err := json.NewDecoder(resp.Body).Decode(&body); err == nil {
-			return &YQError{
-				Message: fmt.Sprintf("Error occurred. http code=%d, status=%v, msg=%v, details=%v",
-					resp.StatusCode, body["status"], body["message"], body["details"]),
-				Status:  fmt.Sprintf("%v", body["status"]),
-				Msg:     fmt.Sprintf("%v", body["message"]),
-				Details: body["details"],
-			}
+// limitedBody fails reads once more than remaining bytes have been read.
+type limitedBody struct {
+	io.ReadCloser
+	remaining int64
+}
+
+func (b *limitedBody) Read(p []byte) (int, error) {
+	if b.remaining < 0 {
+		return 0, ErrResponseTooLarge
+	}
+	if int64(len(p)) > b.remaining+1 {
+		p = p[:b.remaining+1]
+	}
+	n, err := b.ReadCloser.Read(p)
+	if int64(n) > b.remaining {
+		n = int(b.remaining)
+		b.remaining = -1
+		return n, ErrResponseTooLarge
+	}
+	b.remaining -= int64(n)
+	return n, err
+}
+
+// validateHTTPError returns nil if the response has one of the expected status
+// codes and a *YQError otherwise. Error bodies that are empty or not JSON
+// produce an error carrying just the status code.
+func (c *Client) validateHTTPError(resp *http.Response, expectedCodes ...int) error {
+	for _, code := range expectedCodes {
+		if resp.StatusCode == code {
+			return nil
 		}
+	}
+
+	var requestID string
+	if resp.Request != nil {
+		requestID = resp.Request.Header.Get("x-request-id")
+	}
+
+	retryAfter := strings.TrimSpace(resp.Header.Get("Retry-After"))
+
+	data, _ := io.ReadAll(resp.Body)
+	var body map[string]interface{}
+	if len(bytes.TrimSpace(data)) > 0 && json.Unmarshal(data, &body) == nil {
 		return &YQError{
-			Message: fmt.Sprintf("Error occurred: %d", resp.StatusCode),
+			Message: fmt.Sprintf("Error occurred. http code=%d, status=%v, msg=%v, details=%v",
+				resp.StatusCode, body["status"], body["message"], body["details"]),
+			Status:     fmt.Sprintf("%v", body["status"]),
+			Msg:        fmt.Sprintf("%v", body["message"]),
+			Details:    body["details"],
+			RequestID:  requestID,
+			StatusCode: resp.StatusCode,
+			retryAfter: retryAfter,
 		}
 	}
-	return nil
+	return &YQError{
+		Message:    fmt.Sprintf("Error occurred: %d", resp.StatusCode),
+		RequestID:  requestID,
+		StatusCode: resp.StatusCode,
+		retryAfter: retryAfter,
+	}
 }
 
-// CreateQuery creates a new query.
-func (c *Client) CreateQuery(ctx context.Context, queryText, queryType, name, description, idempotencyKey, requestID string) (string, error) {
-	params := c.buildParams()
+// CreateQueryRequest describes a query to be created.
+type CreateQueryRequest struct {
+	Text        string
+	Type        string
+	Name        string
+	Description string
+
+	// Extra holds additional body fields the typed fields don't cover yet.
+	// It is merged into the request body; typed fields that are set win on
+	// key collision.
+	Extra map[string]interface{}
+}
 
-	body := map[string]string{}
-	if queryText != "" {
-		body["text"] = queryText
+func (r CreateQueryRequest) body() map[string]interface{} {
+	body := make(map[string]interface{}, len(r.Extra)+4)
+	for k, v := range r.Extra {
+		body[k] = v
 	}
-	if queryType != "" {
-		body["type"] = queryType
+	if r.Text != "" {
+		body["text"] = r.Text
 	}
-	if name != "" {
-		body["name"] = name
+	if r.Type != "" {
+		body["type"] = r.Type
 	}
-	if description != "" {
-		body["description"] = description
+	if r.Name != "" {
+		body["name"] = r.Name
+	}
+	if r.Description != "" {
+		body["description"] = r.Description
+	}
+	return body
+}
+
+// CreateQuery creates a new query.
+func (c *Client) CreateQuery(ctx context.Context, queryText, queryType, name, description, idempotencyKey, requestID string) (string, error) {
+	return c.CreateQueryFromRequest(ctx, CreateQueryRequest{
+		Text:        queryText,
+		Type:        queryType,
+		Name:        name,
+		Description: description,
+	}, idempotencyKey, requestID)
+}
+
+// CreateQueryFromRequest creates a new query described by req.
+func (c *Client) CreateQueryFromRequest(ctx context.Context, req CreateQueryRequest, idempotencyKey, requestID string) (string, error) {
+	if c.config.DedupeCreates && idempotencyKey != "" {
+		ttl := c.config.DedupeTTL
+		if ttl == 0 {
+			ttl = DefaultDedupeTTL
+		}
+		return c.creates.do(ctx, idempotencyKey, ttl, func(ctx context.Context) (string, error) {
+			return c.createQuery(ctx, req, idempotencyKey, requestID)
+		})
 	}
+	return c.createQuery(ctx, req, idempotencyKey, requestID)
+}
+
+func (c *Client) createQuery(ctx context.Context, req CreateQueryRequest, idempotencyKey, requestID string) (string, error) {
+	params := c.buildParams()
 
-	jsonBody, err := json.Marshal(body)
+	jsonBody, err := json.Marshal(req.body())
 	if err != nil {
 		return "", err
 	}
 
-	headers := c.buildHeaders(idempotencyKey, requestID)
-	headers.Set("Content-Type", "application/json")
+	headers := c.buildHeaders(ctx, idempotencyKey, requestID)
 
 	resp, err := c.doRequest(ctx, "POST", c.composeAPIURL("/api/fq/v1/queries", params), headers, bytes.NewBuffer(jsonBody))
 	if err != nil {
@@ -192,7 +727,7 @@ func (c *Client) CreateQuery(ctx context.Context, queryText, queryType, name, de
 	}
 	defer resp.Body.Close()
 
-	if err := c.validateHTTPError(resp, http.StatusOK); err != nil {
+	if err := c.validateHTTPError(resp, http.StatusOK, http.StatusCreated, http.StatusAccepted); err != nil {
 		return "", err
 	}
 
@@ -206,11 +741,67 @@ func (c *Client) CreateQuery(ctx context.Context, queryText, queryType, name, de
 	return result.ID, nil
 }
 
+// ModifyQueryOptions holds the fields of a query to change. Empty fields are
+// left as they are.
+type ModifyQueryOptions struct {
+	Text        string
+	Type        string
+	Name        string
+	Description string
+
+	// Extra is merged into the request body as CreateQueryRequest.Extra is.
+	Extra map[string]interface{}
+}
+
+// ModifyQuery updates the text, type, name or description of a query.
+func (c *Client) ModifyQuery(ctx context.Context, queryID string, opts ModifyQueryOptions, idempotencyKey, requestID string) error {
+	params := c.buildParams()
+
+	// The fields mirror CreateQueryRequest, and so does the body.
+	jsonBody, err := json.Marshal(CreateQueryRequest(opts).body())
+	if err != nil {
+		return err
+	}
+
+	headers := c.buildHeaders(ctx, idempotencyKey, requestID)
+	resp, err := c.doRequest(ctx, "PATCH", c.composeAPIURL(fmt.Sprintf("/api/fq/v1/queries/%s", queryID), params), headers, bytes.NewBuffer(jsonBody))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	return c.validateHTTPError(resp, http.StatusOK, http.StatusNoContent)
+}
+
+// RetryQuery creates a new query with the same text, type and name as an
+// existing one, e.g. after a transient failure, and returns the new query ID.
+// The new description notes which query it retries.
+func (c *Client) RetryQuery(ctx context.Context, queryID string) (string, error) {
+	query, err := c.GetQuery(ctx, queryID, "")
+	if err != nil {
+		return "", err
+	}
+
+	req := CreateQueryRequest{}
+	req.Text, _ = query["text"].(string)
+	req.Type, _ = query["type"].(string)
+	req.Name, _ = query["name"].(string)
+
+	note := "Retry of " + c.ComposeQueryWebLink(queryID)
+	if description, _ := query["description"].(string); description != "" {
+		req.Description = description + "\n\n" + note
+	} else {
+		req.Description = note
+	}
+
+	return c.CreateQueryFromRequest(ctx, req, "", "")
+}
+
 // GetQueryStatus returns the status of a query.
-func (c *Client) GetQueryStatus(ctx context.Context, queryID, requestID string) (string, error) {
+func (c *Client) GetQueryStatus(ctx context.Context, queryID, requestID string) (QueryStatus, error) {
 	params := c.buildParams()
 
-	headers := c.buildHeaders("", requestID)
+	headers := c.buildHeaders(ctx, "", requestID)
 	resp, err := c.doRequest(ctx, "GET", c.composeAPIURL(fmt.Sprintf("/api/fq/v1/queries/%s/status", queryID), params), headers, nil)
 	if err != nil {
 		return "", err
@@ -222,7 +813,7 @@ func (c *Client) GetQueryStatus(ctx context.Context, queryID, requestID string)
 	}
 
 	var result struct {
-		Status string `json:"status"`
+		Status QueryStatus `json:"status"`
 	}
 	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
 		return "", err
@@ -231,11 +822,17 @@ func (c *Client) GetQueryStatus(ctx context.Context, queryID, requestID string)
 	return result.Status, nil
 }
 
+// queryStatus returns the status of a query object as returned by GetQuery.
+func queryStatus(query map[string]interface{}) QueryStatus {
+	status, _ := query["status"].(string)
+	return QueryStatus(status)
+}
+
 // GetQuery returns the details of a query.
 func (c *Client) GetQuery(ctx context.Context, queryID, requestID string) (map[string]interface{}, error) {
 	params := c.buildParams()
 
-	headers := c.buildHeaders("", requestID)
+	headers := c.buildHeaders(ctx, "", requestID)
 	resp, err := c.doRequest(ctx, "GET", c.composeAPIURL(fmt.Sprintf("/api/fq/v1/queries/%s", queryID), params), headers, nil)
 	if err != nil {
 		return nil, err
@@ -258,147 +855,769 @@ func (c *Client) GetQuery(ctx context.Context, queryID, requestID string) (map[s
 func (c *Client) StopQuery(ctx context.Context, queryID, idempotencyKey, requestID string) error {
 	params := c.buildParams()
 
-	headers := c.buildHeaders(idempotencyKey, requestID)
+	headers := c.buildHeaders(ctx, idempotencyKey, requestID)
 	resp, err := c.doRequest(ctx, "POST", c.composeAPIURL(fmt.Sprintf("/api/fq/v1/queries/%s/stop", queryID), params), headers, nil)
 	if err != nil {
 		return err
 	}
 	defer resp.Body.Close()
 
-	return c.validateHTTPError(resp, http.StatusNoContent)
+	// Some gateways answer 200, possibly with a body, instead of 204.
+	return c.validateHTTPError(resp, http.StatusNoContent, http.StatusOK)
+}
+
+// DeleteQuery deletes a query from the project.
+func (c *Client) DeleteQuery(ctx context.Context, queryID, idempotencyKey, requestID string) error {
+	params := c.buildParams()
+
+	headers := c.buildHeaders(ctx, idempotencyKey, requestID)
+	resp, err := c.doRequest(ctx, "DELETE", c.composeAPIURL(fmt.Sprintf("/api/fq/v1/queries/%s", queryID), params), headers, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	// As with StopQuery, accept a 200 from gateways that don't answer 204.
+	return c.validateHTTPError(resp, http.StatusNoContent, http.StatusOK)
+}
+
+// EnsureStopped stops a query unless it has already finished, then waits for
+// it to reach a terminal status and returns that status. Calling it on a
+// finished query is a no-op that returns its status.
+func (c *Client) EnsureStopped(ctx context.Context, queryID string) (QueryStatus, error) {
+	status, err := c.GetQueryStatus(ctx, queryID, "")
+	if err != nil {
+		return "", err
+	}
+	if status.IsTerminal() {
+		return status, nil
+	}
+
+	if err := c.StopQuery(ctx, queryID, "", ""); err != nil {
+		// The query may have finished after its status was checked.
+		if status, statusErr := c.GetQueryStatus(ctx, queryID, ""); statusErr == nil && status.IsTerminal() {
+			return status, nil
+		}
+		return "", err
+	}
+
+	return c.WaitQueryToComplete(ctx, queryID, 0, false)
 }
 
 // WaitQueryToComplete waits for a query to complete.
-func (c *Client) WaitQueryToComplete(ctx context.Context, queryID string, executionTimeout time.Duration, stopOnTimeout bool) (string, error) {
+func (c *Client) WaitQueryToComplete(ctx context.Context, queryID string, executionTimeout time.Duration, stopOnTimeout bool) (QueryStatus, error) {
+	return c.waitQueryToComplete(ctx, queryID, executionTimeout, stopOnTimeout, "")
+}
+
+func (c *Client) waitQueryToComplete(ctx context.Context, queryID string, executionTimeout time.Duration, stopOnTimeout bool, stopIdempotencyKey string) (QueryStatus, error) {
 	startTime := time.Now()
-	delay := 200 * time.Millisecond
+	delay := c.nextPollDelay(0, 0)
+	var lastStatus QueryStatus
+	failures := 0
 
 	for {
 		if executionTimeout > 0 && time.Since(startTime) > executionTimeout {
 			if stopOnTimeout {
-				_ = c.StopQuery(ctx, queryID, "", "")
+				c.stopQueryBestEffort(ctx, queryID, stopIdempotencyKey)
 			}
-			return "", fmt.Errorf("query %s execution timeout", queryID)
+			return "", &WaitError{QueryID: queryID, LastStatus: lastStatus, Err: ErrExecutionTimeout}
 		}
 
-		status, err := c.GetQueryStatus(ctx, queryID, "")
+		status, err := c.pollStatus(ctx, queryID, &failures)
 		if err != nil {
+			if ctx.Err() != nil {
+				return "", c.interruptWait(ctx, queryID, lastStatus, stopOnTimeout, stopIdempotencyKey)
+			}
 			return "", err
 		}
-
-		if status != "RUNNING" && status != "PENDING" {
-			return status, nil
+		if status != "" {
+			lastStatus = status
+			if status.IsTerminal() {
+				return status, nil
+			}
 		}
 
 		select {
 		case <-ctx.Done():
-			return "", ctx.Err()
+			return "", c.interruptWait(ctx, queryID, lastStatus, stopOnTimeout, stopIdempotencyKey)
 		case <-time.After(delay):
-			delay *= 2
-			if delay > 2*time.Second {
-				delay = 2 * time.Second
-			}
+			delay = c.nextPollDelay(time.Since(startTime), delay)
 		}
 	}
 }
 
-// WaitQueryToSucceed waits for a query to complete successfully.
-func (c *Client) WaitQueryToSucceed(ctx context.Context, queryID string, executionTimeout time.Duration, stopOnTimeout bool) (int, error) {
-	status, err := c.WaitQueryToComplete(ctx, queryID, executionTimeout, stopOnTimeout)
-	if err != nil {
-		return 0, err
+// interruptWait builds the error for a wait ended by its context. If the
+// context deadline expired, it counts as an execution timeout and the query is
+// stopped when stopOnTimeout is set.
+func (c *Client) interruptWait(ctx context.Context, queryID string, lastStatus QueryStatus, stopOnTimeout bool, stopIdempotencyKey string) error {
+	if stopOnTimeout && errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		c.stopQueryBestEffort(ctx, queryID, stopIdempotencyKey)
 	}
+	return &WaitError{QueryID: queryID, LastStatus: lastStatus, Err: ctx.Err()}
+}
 
-	query, err := c.GetQuery(ctx, queryID, "")
-	if err != nil {
-		return 0, err
-	}
+// stopQueryBestEffort stops a query on behalf of a wait that gave up. The
+// caller's context may already be done at that point, so the stop gets its own
+// StopTimeout while keeping the caller's context values.
+func (c *Client) stopQueryBestEffort(ctx context.Context, queryID, idempotencyKey string) {
+	stopCtx, cancel := context.WithTimeout(detachedContext{ctx}, StopTimeout)
+	defer cancel()
+	_ = c.StopQuery(stopCtx, queryID, idempotencyKey, "")
+}
 
-	if status != "COMPLETED" {
-		issues, _ := query["issues"].([]interface{})
-		return 0, fmt.Errorf("query %s failed with issues=%v", queryID, issues)
-	}
+// WaitForStatus waits for a query to reach the target status. If the query
+// reaches a different terminal status first, the error is a
+// *StatusMismatchError; if the timeout or the context ends the wait, it is a
+// *WaitError.
+func (c *Client) WaitForStatus(ctx context.Context, queryID string, target QueryStatus, timeout time.Duration) error {
+	startTime := time.Now()
+	delay := c.nextPollDelay(0, 0)
+	var lastStatus QueryStatus
+	failures := 0
 
-	resultSets, ok := query["result_sets"].([]interface{})
-	if !ok {
-		return 0, fmt.Errorf("unexpected result_sets format")
-	}
+	for {
+		if timeout > 0 && time.Since(startTime) > timeout {
+			return &WaitError{QueryID: queryID, LastStatus: lastStatus, Err: ErrExecutionTimeout}
+		}
 
-	return len(resultSets), nil
+		status, err := c.pollStatus(ctx, queryID, &failures)
+		if err != nil {
+			if ctx.Err() != nil {
+				return &WaitError{QueryID: queryID, LastStatus: lastStatus, Err: ctx.Err()}
+			}
+			return err
+		}
+		if status != "" {
+			lastStatus = status
+			if status == target {
+				return nil
+			}
+			if status.IsTerminal() {
+				return &StatusMismatchError{QueryID: queryID, Status: status, Target: target}
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return &WaitError{QueryID: queryID, LastStatus: lastStatus, Err: ctx.Err()}
+		case <-time.After(delay):
+			delay = c.nextPollDelay(time.Since(startTime), delay)
+		}
+	}
 }
 
-// GetQueryResultSetPage returns a page of a query result set.
-func (c *Client) GetQueryResultSetPage(ctx context.Context, queryID string, resultSetIndex int, offset, limit int, rawFormat bool, requestID string) (map[string]interface{}, error) {
-	params := c.buildParams()
-	if offset > 0 {
-		params["offset"] = strconv.Itoa(offset)
+// nextPollDelay returns the delay before the next status poll. Without a
+// configured PollInterval it doubles the previous delay from 200ms up to 2s.
+func (c *Client) nextPollDelay(elapsed, previous time.Duration) time.Duration {
+	if c.config.PollInterval != nil {
+		return c.config.PollInterval(elapsed)
 	}
-	if limit > 0 {
-		params["limit"] = strconv.Itoa(limit)
+	if previous == 0 {
+		return 200 * time.Millisecond
 	}
-
-	headers := c.buildHeaders("", requestID)
-	url := c.composeAPIURL(fmt.Sprintf("/api/fq/v1/queries/%s/results/%d", queryID, resultSetIndex), params)
-
-	resp, err := c.doRequest(ctx, "GET", url, headers, nil)
-	if err != nil {
-		return nil, err
+	if previous*2 > 2*time.Second {
+		return 2 * time.Second
 	}
-	defer resp.Body.Close()
+	return previous * 2
+}
 
-	if err := c.validateHTTPError(resp, http.StatusOK); err != nil {
-		return nil, err
+// AdaptivePollInterval is a PollIntervalFunc that polls young queries often
+// and backs off as they keep running: every 200ms for the first 10 seconds,
+// every second up to a minute, every 5 seconds up to 10 minutes and every 30
+// seconds after that.
+func AdaptivePollInterval(elapsed time.Duration) time.Duration {
+	switch {
+	case elapsed < 10*time.Second:
+		return 200 * time.Millisecond
+	case elapsed < time.Minute:
+		return time.Second
+	case elapsed < 10*time.Minute:
+		return 5 * time.Second
+	default:
+		return 30 * time.Second
 	}
+}
 
-	var result map[string]interface{}
-	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
-		return nil, err
+// MaxPollFailures is the number of consecutive transient status poll failures
+// a wait tolerates before giving up.
+const MaxPollFailures = 3
+
+// pollStatus fetches the status of a query for a wait loop, with the poll
+// retry policy and timeout. If failures is not nil, transient errors are
+// counted in it and reported as an empty status, so that the loop polls again
+// at its next tick, until more than MaxPollFailures polls failed in a row.
+func (c *Client) pollStatus(ctx context.Context, queryID string, failures *int) (QueryStatus, error) {
+	var policy RetryPolicy
+	if c.config.PollRetryPolicy != nil {
+		policy = *c.config.PollRetryPolicy
+	}
+	pollCtx := withRetryPolicy(ctx, policy)
+	if c.config.PollTimeout > 0 {
+		var cancel context.CancelFunc
+		pollCtx, cancel = context.WithTimeout(pollCtx, c.config.PollTimeout)
+		defer cancel()
 	}
 
-	return result, nil
+	status, err := c.GetQueryStatus(pollCtx, queryID, "")
+	if err != nil {
+		if failures == nil || ctx.Err() != nil || !isTransientError(err) {
+			return "", err
+		}
+		*failures++
+		if *failures > MaxPollFailures {
+			return "", err
+		}
+		return "", nil
+	}
+	if failures != nil {
+		*failures = 0
+	}
+	return status, nil
+}
+
+// isTransientError reports whether a failed request may succeed if repeated:
+// transport errors, throttling and server errors.
+func isTransientError(err error) bool {
+	if errors.Is(err, ErrClientClosed) || errors.Is(err, ErrResponseTooLarge) {
+		return false
+	}
+	var yqErr *YQError
+	if errors.As(err, &yqErr) {
+		return yqErr.StatusCode == http.StatusTooManyRequests || yqErr.StatusCode >= 500
+	}
+	return true
+}
+
+// WaitQueryToSucceed waits for a query to complete successfully.
+func (c *Client) WaitQueryToSucceed(ctx context.Context, queryID string, executionTimeout time.Duration, stopOnTimeout bool) (int, error) {
+	return c.waitQueryToSucceed(ctx, queryID, executionTimeout, stopOnTimeout, "")
+}
+
+// WaitQueryToSucceedE is WaitQueryToSucceed returning all result sets of the
+// query converted instead of their count. If the query fails, the error is an
+// *IssuesError.
+func (c *Client) WaitQueryToSucceedE(ctx context.Context, queryID string, executionTimeout time.Duration, stopOnTimeout bool) ([]*Results, error) {
+	resultSetCount, err := c.waitQueryToSucceed(ctx, queryID, executionTimeout, stopOnTimeout, "")
+	if err != nil {
+		return nil, err
+	}
+	return c.collectResultSets(ctx, queryID, resultSetCount)
+}
+
+func (c *Client) waitQueryToSucceed(ctx context.Context, queryID string, executionTimeout time.Duration, stopOnTimeout bool, stopIdempotencyKey string) (int, error) {
+	status, err := c.waitQueryToComplete(ctx, queryID, executionTimeout, stopOnTimeout, stopIdempotencyKey)
+	if err != nil {
+		return 0, err
+	}
+
+	query, err := c.GetQuery(ctx, queryID, "")
+	if err != nil {
+		return 0, err
+	}
+
+	return c.finishedResultSetCount(ctx, queryID, status, query)
+}
+
+// finishedResultSetCount returns the result set count of a finished query, or
+// an *IssuesError carrying the correlation ID of ctx if it didn't complete
+// successfully. Queries without result sets, e.g. DDL, may omit result_sets
+// altogether; that counts as zero unless RequireResultSets is set.
+func (c *Client) finishedResultSetCount(ctx context.Context, queryID string, status QueryStatus, query map[string]interface{}) (int, error) {
+	if status != StatusCompleted {
+		return 0, &IssuesError{
+			QueryID:   queryID,
+			Status:    status,
+			Issues:    parseIssues(query["issues"]),
+			RequestID: correlationIDFromContext(ctx),
+		}
+	}
+
+	raw, present := query["result_sets"]
+	if !present || raw == nil {
+		if c.config.RequireResultSets {
+			return 0, fmt.Errorf("query %s: %w", queryID, ErrNoResultSets)
+		}
+		return 0, nil
+	}
+	resultSets, ok := raw.([]interface{})
+	if !ok {
+		return 0, fmt.Errorf("query %s: unexpected result_sets format %T", queryID, raw)
+	}
+
+	return len(resultSets), nil
+}
+
+// WaitPolicy controls how long to wait for a query and what to do on timeout.
+type WaitPolicy struct {
+	// ExecutionTimeout limits the wait. Zero means wait until the context is done.
+	ExecutionTimeout time.Duration
+	// StopOnTimeout stops the query when the wait times out.
+	StopOnTimeout bool
+}
+
+// RunAndCollect creates a query, waits for it to succeed and returns all its
+// result sets converted. If the query fails, the error is an *IssuesError.
+func (c *Client) RunAndCollect(ctx context.Context, req CreateQueryRequest, policy WaitPolicy) ([]*Results, error) {
+	queryID, resultSetCount, err := c.RunQuery(ctx, req, "", policy.ExecutionTimeout, policy.StopOnTimeout)
+	if err != nil {
+		return nil, err
+	}
+	return c.collectResultSets(ctx, queryID, resultSetCount)
+}
+
+// AttachAndCollect waits for an existing query, e.g. one created by another
+// process, to succeed and returns all its result sets converted. Queries that
+// have already finished are collected without waiting. If the query fails,
+// the error is an *IssuesError.
+func (c *Client) AttachAndCollect(ctx context.Context, queryID string, policy WaitPolicy) ([]*Results, error) {
+	query, err := c.GetQuery(ctx, queryID, "")
+	if err != nil {
+		return nil, err
+	}
+
+	var resultSetCount int
+	if status := queryStatus(query); status.IsTerminal() {
+		resultSetCount, err = c.finishedResultSetCount(ctx, queryID, status, query)
+	} else {
+		resultSetCount, err = c.waitQueryToSucceed(ctx, queryID, policy.ExecutionTimeout, policy.StopOnTimeout, "")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return c.collectResultSets(ctx, queryID, resultSetCount)
+}
+
+func (c *Client) collectResultSets(ctx context.Context, queryID string, resultSetCount int) ([]*Results, error) {
+	results := make([]*Results, resultSetCount)
+	for i := range results {
+		r, err := c.fetchResultSet(ctx, queryID, i, nil)
+		if err != nil {
+			return nil, err
+		}
+		results[i] = r
+	}
+	return results, nil
+}
+
+// RunQuery creates a query and waits for it to succeed, returning the query ID
+// and its result set count.
+//
+// If idempotencyKey is set, it is the base for the keys of the underlying
+// calls: "<key>-create" for creating the query and "<key>-stop" for the stop
+// sent on timeout. Retrying the whole RunQuery with the same key therefore
+// attaches to the same query instead of creating a new one.
+func (c *Client) RunQuery(ctx context.Context, req CreateQueryRequest, idempotencyKey string, executionTimeout time.Duration, stopOnTimeout bool) (string, int, error) {
+	queryID, err := c.CreateQueryFromRequest(ctx, req, deriveIdempotencyKey(idempotencyKey, "create"), "")
+	if err != nil {
+		return "", 0, err
+	}
+
+	resultSetCount, err := c.waitQueryToSucceed(ctx, queryID, executionTimeout, stopOnTimeout, deriveIdempotencyKey(idempotencyKey, "stop"))
+	if err != nil {
+		return queryID, 0, err
+	}
+
+	return queryID, resultSetCount, nil
+}
+
+func deriveIdempotencyKey(base, operation string) string {
+	if base == "" {
+		return ""
+	}
+	return base + "-" + operation
+}
+
+// GetResultSetCount returns the number of result sets of a completed query
+// without waiting for it. It returns ErrQueryNotCompleted if the query hasn't
+// completed yet.
+func (c *Client) GetResultSetCount(ctx context.Context, queryID string) (int, error) {
+	query, err := c.GetQuery(ctx, queryID, "")
+	if err != nil {
+		return 0, err
+	}
+
+	if status := queryStatus(query); status != StatusCompleted {
+		return 0, fmt.Errorf("query %s has status %s: %w", queryID, status, ErrQueryNotCompleted)
+	}
+
+	resultSets, _ := query["result_sets"].([]interface{})
+	return len(resultSets), nil
+}
+
+// ResultSetMeta describes the shape of a query result set.
+type ResultSetMeta struct {
+	Index int
+	// RowCount is the number of rows, or -1 if the API doesn't report it.
+	RowCount  int64
+	Truncated bool
+	// Bytes is the size of the result set, or zero if the API doesn't report it.
+	Bytes   int64
+	Columns []Column
+}
+
+// GetResultSetMeta returns the metadata of a query result set. It is read from
+// the query object; only if that lacks the column schema is a single row of
+// the result set fetched.
+func (c *Client) GetResultSetMeta(ctx context.Context, queryID string, resultSetIndex int) (*ResultSetMeta, error) {
+	query, err := c.GetQuery(ctx, queryID, "")
+	if err != nil {
+		return nil, err
+	}
+
+	meta, err := resultSetMetaFromQuery(query, queryID, resultSetIndex)
+	if err != nil {
+		return nil, err
+	}
+
+	if meta.Columns == nil {
+		meta.Columns, err = c.fetchResultSetColumns(ctx, queryID, resultSetIndex)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return meta, nil
+}
+
+// fetchResultSetColumns reads the schema of a result set from a single-row page.
+func (c *Client) fetchResultSetColumns(ctx context.Context, queryID string, resultSetIndex int) ([]Column, error) {
+	page, err := c.GetQueryResultSetPage(ctx, queryID, resultSetIndex, 0, 1, true, "")
+	if err != nil {
+		return nil, err
+	}
+	return parseColumns(page["columns"]), nil
+}
+
+// DescribeAndSampleResultSet returns the schema and the first sampleRows
+// converted rows of a result set, fetched in a single request, e.g. to show a
+// preview. sampleRows must be between 1 and 1000, the largest page the API
+// serves.
+func (c *Client) DescribeAndSampleResultSet(ctx context.Context, queryID string, resultSetIndex int, sampleRows int) (*Results, error) {
+	if sampleRows <= 0 || sampleRows > resultSetPageSize {
+		return nil, fmt.Errorf("invalid sample size %d", sampleRows)
+	}
+
+	page, err := c.GetResultSetPage(ctx, queryID, resultSetIndex, 0, sampleRows)
+	if err != nil {
+		return nil, err
+	}
+	return page.Results(c.resultsOptions()...), nil
+}
+
+// ListResultSets returns the metadata of every result set of a query. Row
+// counts and truncation are read from the query object. The YQ API doesn't
+// include column schemas there, so unless a deployment does, each result set
+// costs one extra request for a single-row page to read its columns.
+func (c *Client) ListResultSets(ctx context.Context, queryID string) ([]ResultSetMeta, error) {
+	query, err := c.GetQuery(ctx, queryID, "")
+	if err != nil {
+		return nil, err
+	}
+
+	resultSets, _ := query["result_sets"].([]interface{})
+	metas := make([]ResultSetMeta, len(resultSets))
+	for i := range resultSets {
+		meta, err := resultSetMetaFromQuery(query, queryID, i)
+		if err != nil {
+			return nil, err
+		}
+		if meta.Columns == nil {
+			meta.Columns, err = c.fetchResultSetColumns(ctx, queryID, i)
+			if err != nil {
+				return nil, err
+			}
+		}
+		metas[i] = *meta
+	}
+
+	return metas, nil
+}
+
+// GetAllResultSetSchemas returns the column schema of every result set of a
+// query. A query without result sets yields an empty slice. As the API doesn't
+// expose schemas without rows, this fetches one row per result set; see
+// ListResultSets.
+func (c *Client) GetAllResultSetSchemas(ctx context.Context, queryID string) ([][]Column, error) {
+	metas, err := c.ListResultSets(ctx, queryID)
+	if err != nil {
+		return nil, err
+	}
+
+	schemas := make([][]Column, len(metas))
+	for i, meta := range metas {
+		schemas[i] = meta.Columns
+	}
+	return schemas, nil
+}
+
+func resultSetMetaFromQuery(query map[string]interface{}, queryID string, resultSetIndex int) (*ResultSetMeta, error) {
+	resultSets, _ := query["result_sets"].([]interface{})
+	if resultSetIndex < 0 || resultSetIndex >= len(resultSets) {
+		return nil, fmt.Errorf("query %s has no result set %d", queryID, resultSetIndex)
+	}
+
+	meta := &ResultSetMeta{Index: resultSetIndex, RowCount: -1}
+	if info, ok := resultSets[resultSetIndex].(map[string]interface{}); ok {
+		if rowCount, ok := info["rows_count"].(float64); ok {
+			meta.RowCount = int64(rowCount)
+		}
+		meta.Truncated, _ = info["truncated"].(bool)
+		if size, ok := info["bytes"].(float64); ok {
+			meta.Bytes = int64(size)
+		}
+		if columns, ok := info["columns"]; ok {
+			meta.Columns = parseColumns(columns)
+		}
+	}
+	return meta, nil
+}
+
+// EstimateResultSetSize returns the row count of a result set and an estimate
+// of its size in bytes, so callers can pick between buffering and streaming.
+// If the API doesn't report the size, it is extrapolated from the average
+// encoded row width of the first page. rows is -1 if the row count is unknown,
+// in which case no size can be estimated.
+func (c *Client) EstimateResultSetSize(ctx context.Context, queryID string, resultSetIndex int) (rows int64, size int64, err error) {
+	query, err := c.GetQuery(ctx, queryID, "")
+	if err != nil {
+		return 0, 0, err
+	}
+
+	meta, err := resultSetMetaFromQuery(query, queryID, resultSetIndex)
+	if err != nil {
+		return 0, 0, err
+	}
+	if meta.Bytes > 0 || meta.RowCount <= 0 {
+		return meta.RowCount, meta.Bytes, nil
+	}
+
+	page, err := c.GetQueryResultSetPage(ctx, queryID, resultSetIndex, 0, 100, true, "")
+	if err != nil {
+		return 0, 0, err
+	}
+	sample, _ := page["rows"].([]interface{})
+	if len(sample) == 0 {
+		return meta.RowCount, 0, nil
+	}
+
+	encoded, err := json.Marshal(sample)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	return meta.RowCount, int64(len(encoded)) * meta.RowCount / int64(len(sample)), nil
+}
+
+// GetQueryResultSetPage returns a page of a query result set. A zero limit
+// requests the API's default page size of defaultPageLimit rows; the API has
+// no schema-only page, use ListResultSets or GetAllResultSetSchemas for that.
+// Negative offsets and limits are rejected.
+func (c *Client) GetQueryResultSetPage(ctx context.Context, queryID string, resultSetIndex int, offset, limit int, rawFormat bool, requestID string) (map[string]interface{}, error) {
+	if offset < 0 || limit < 0 {
+		return nil, fmt.Errorf("invalid page offset=%d limit=%d", offset, limit)
+	}
+
+	params := c.buildParams()
+	if offset > 0 {
+		params["offset"] = strconv.Itoa(offset)
+	}
+	if limit > 0 {
+		params["limit"] = strconv.Itoa(limit)
+	}
+
+	headers := c.buildHeaders(ctx, "", requestID)
+	url := c.composeAPIURL(fmt.Sprintf("/api/fq/v1/queries/%s/results/%d", queryID, resultSetIndex), params)
+
+	resp, err := c.doRequest(ctx, "GET", url, headers, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if err := c.validateHTTPError(resp, http.StatusOK); err != nil {
+		return nil, err
+	}
+
+	var result map[string]interface{}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// ResultSetPage is a single page of raw rows of a result set.
+type ResultSetPage struct {
+	// Offset is the position of the first row of the page.
+	Offset int
+	// Rows holds the raw rows as returned by the API.
+	Rows []interface{}
+	// HasMore reports whether rows past this page may exist. The API does
+	// not report it, so it is inferred from a full page; a result set whose
+	// size is a multiple of the limit ends with an empty page.
+	HasMore bool
+	// Total is the number of rows in the result set, or -1 until the last
+	// page has been reached.
+	Total int
+
+	columns interface{}
+}
+
+// Columns returns the result set schema.
+func (p *ResultSetPage) Columns() []Column {
+	return parseColumns(p.columns)
+}
+
+// Results returns the page rows ready for conversion.
+func (p *ResultSetPage) Results(opts ...ResultsOption) *Results {
+	return NewYQResults(map[string]interface{}{
+		"rows":    p.Rows,
+		"columns": p.columns,
+	}, opts...)
+}
+
+// GetResultSetPage fetches the page of a result set starting at offset.
+// The API reports neither the row count nor whether more rows follow, so
+// HasMore is set when the page is full and Total only once it is not.
+func (c *Client) GetResultSetPage(ctx context.Context, queryID string, resultSetIndex int, offset, limit int) (*ResultSetPage, error) {
+	part, err := c.GetQueryResultSetPage(ctx, queryID, resultSetIndex, offset, limit, true, "")
+	if err != nil {
+		return nil, err
+	}
+	return newResultSetPage(part, offset, limit)
+}
+
+func newResultSetPage(part map[string]interface{}, offset, limit int) (*ResultSetPage, error) {
+	rows, ok := part["rows"].([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("unexpected rows format")
+	}
+
+	page := &ResultSetPage{
+		Offset:  offset,
+		Rows:    rows,
+		Total:   -1,
+		columns: part["columns"],
+	}
+	if limit == 0 {
+		limit = defaultPageLimit
+	}
+	page.HasMore = len(rows) == limit
+	if !page.HasMore {
+		page.Total = offset + len(rows)
+	}
+	return page, nil
+}
+
+// GetResultSetRange returns the converted rows [offset, offset+limit) of a
+// query result set, e.g. to back a paginated view. Ranges larger than a page
+// are fetched in several requests.
+func (c *Client) GetResultSetRange(ctx context.Context, queryID string, resultSetIndex int, offset, limit int) (*Results, error) {
+	if offset < 0 || limit <= 0 {
+		return nil, fmt.Errorf("invalid range offset=%d limit=%d", offset, limit)
+	}
+
+	var columns interface{}
+	rows := []interface{}{}
+	for len(rows) < limit {
+		pageLimit := limit - len(rows)
+		if pageLimit > resultSetPageSize {
+			pageLimit = resultSetPageSize
+		}
+
+		page, err := c.GetResultSetPage(ctx, queryID, resultSetIndex, offset+len(rows), pageLimit)
+		if err != nil {
+			return nil, err
+		}
+		if columns == nil {
+			columns = page.columns
+		}
+		rows = append(rows, page.Rows...)
+
+		if !page.HasMore || len(page.Rows) == 0 {
+			break
+		}
+	}
+
+	return NewYQResults(map[string]interface{}{
+		"rows":    rows,
+		"columns": columns,
+	}, c.resultsOptions()...), nil
 }
 
 // GetQueryResultSet returns a query result set.
 func (c *Client) GetQueryResultSet(ctx context.Context, queryID string, resultSetIndex int, rawFormat bool) (map[string]interface{}, error) {
+	return c.GetQueryResultSetUntil(ctx, queryID, resultSetIndex, rawFormat, nil)
+}
+
+// GetQueryResultSetUntil returns a query result set, stopping early once stop
+// returns true. stop is called with the raw rows of every fetched page; the
+// page it stops on is included in the result. The result holds only the
+// "rows" and "columns" keys; GetResultSets also reports whether the rows were
+// truncated by MaxFetchDuration and their approximate size.
+func (c *Client) GetQueryResultSetUntil(ctx context.Context, queryID string, resultSetIndex int, rawFormat bool, stop func(page []interface{}) bool) (map[string]interface{}, error) {
+	r, err := c.fetchResultSet(ctx, queryID, resultSetIndex, stop)
+	if err != nil {
+		return nil, err
+	}
+	if rawFormat {
+		return r.RawResults(), nil
+	}
+	return r.Results(), nil
+}
+
+// fetchResultSet pages through a result set as GetQueryResultSetUntil does.
+// The returned Results carry the size of the rows summed up while paging and
+// whether MaxFetchDuration with TruncateOnMaxFetchDuration cut them short.
+func (c *Client) fetchResultSet(ctx context.Context, queryID string, resultSetIndex int, stop func(page []interface{}) bool) (*Results, error) {
 	offset := 0
-	limit := 1000
+	limit := resultSetPageSize
 	var columns interface{}
 	var rows []interface{}
+	var size int64
+	truncated := false
+	startTime := c.now()
 
 	for {
-		part, err := c.GetQueryResultSetPage(ctx, queryID, resultSetIndex, offset, limit, rawFormat, "")
+		page, err := c.GetResultSetPage(ctx, queryID, resultSetIndex, offset, limit)
 		if err != nil {
 			return nil, err
 		}
 
 		if columns == nil {
-			columns = part["columns"]
+			columns = page.columns
 		}
 
-		r, ok := part["rows"].([]interface{})
-		if !ok {
-			return nil, fmt.Errorf("unexpected rows format")
+		rows = append(rows, page.Rows...)
+		size += approxSize(page.Rows)
+
+		if stop != nil && stop(page.Rows) {
+			break
 		}
 
-		rows = append(rows, r...)
+		if !page.HasMore || len(page.Rows) == 0 {
+			break
+		}
 
-		if len(r) != limit {
+		if max := c.config.MaxFetchDuration; max > 0 && c.now().Sub(startTime) >= max {
+			if !c.config.TruncateOnMaxFetchDuration {
+				return nil, fmt.Errorf("query %s result set %d: %w", queryID, resultSetIndex, ErrFetchDurationExceeded)
+			}
+			truncated = true
 			break
 		}
 
-		offset += limit
+		offset += len(page.Rows)
 	}
 
-	result := map[string]interface{}{
+	r := NewYQResults(map[string]interface{}{
 		"rows":    rows,
 		"columns": columns,
-	}
-
-	if rawFormat {
-		return result, nil
-	}
-
-	return NewYQResults(result).Results(), nil
+	}, c.resultsOptions()...)
+	r.approxBytes = size
+	r.truncated = truncated
+	return r, nil
 }
 
 // GetQueryAllResultSets returns all result sets of a query.
@@ -419,10 +1638,109 @@ func (c *Client) GetQueryAllResultSets(ctx context.Context, queryID string, resu
 	return results, nil
 }
 
-// GetOpenAPISpec returns the OpenAPI specification of the YQ HTTP API.
+// GetResultSets returns the converted result sets of a completed query with
+// the given indices, in the given order. Indices are validated against the
+// query's result set count before anything is fetched.
+func (c *Client) GetResultSets(ctx context.Context, queryID string, indices []int) ([]*Results, error) {
+	count, err := c.GetResultSetCount(ctx, queryID)
+	if err != nil {
+		return nil, err
+	}
+	for _, idx := range indices {
+		if idx < 0 || idx >= count {
+			return nil, fmt.Errorf("query %s: result set index %d out of range [0, %d)", queryID, idx, count)
+		}
+	}
+
+	results := make([]*Results, len(indices))
+	for i, idx := range indices {
+		r, err := c.fetchResultSet(ctx, queryID, idx, nil)
+		if err != nil {
+			return nil, err
+		}
+		results[i] = r
+	}
+	return results, nil
+}
+
+// GetResultSetsUnioned fetches the first count result sets of a query and
+// concatenates their rows into one Results. All result sets must have the
+// same columns.
+func (c *Client) GetResultSetsUnioned(ctx context.Context, queryID string, count int) (*Results, error) {
+	if count <= 0 {
+		return nil, fmt.Errorf("query %s: no result sets to union", queryID)
+	}
+
+	var columns interface{}
+	var schema []Column
+	var rows []interface{}
+	var size int64
+	truncated := false
+	for i := 0; i < count; i++ {
+		part, err := c.fetchResultSet(ctx, queryID, i, nil)
+		if err != nil {
+			return nil, err
+		}
+
+		raw := part.RawResults()
+		partSchema := parseColumns(raw["columns"])
+		if i == 0 {
+			columns = raw["columns"]
+			schema = partSchema
+		} else if !equalColumns(schema, partSchema) {
+			return nil, fmt.Errorf("query %s: result set %d columns %v differ from %v", queryID, i, partSchema, schema)
+		}
+
+		r, _ := raw["rows"].([]interface{})
+		rows = append(rows, r...)
+		size += part.ApproxBytes()
+		truncated = truncated || part.Truncated()
+	}
+
+	union := NewYQResults(map[string]interface{}{
+		"rows":    rows,
+		"columns": columns,
+	}, c.resultsOptions()...)
+	union.approxBytes = size
+	union.truncated = truncated
+	return union, nil
+}
+
+// GetOpenAPISpec returns the OpenAPI specification of the YQ HTTP API. With
+// CacheOpenAPISpec set, concurrent calls made before the first fetch finishes
+// each fetch the spec themselves rather than wait on one another.
 func (c *Client) GetOpenAPISpec(ctx context.Context) (string, error) {
+	if c.config.CacheOpenAPISpec {
+		c.specMu.Lock()
+		spec := c.spec
+		c.specMu.Unlock()
+		if spec != "" {
+			return spec, nil
+		}
+	}
+
+	spec, err := c.fetchOpenAPISpec(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	if c.config.CacheOpenAPISpec {
+		c.specMu.Lock()
+		c.spec = spec
+		c.specMu.Unlock()
+	}
+	return spec, nil
+}
+
+func (c *Client) fetchOpenAPISpec(ctx context.Context) (string, error) {
 	params := c.buildParams()
-	resp, err := c.doRequest(ctx, "GET", c.composeAPIURL("/resources/v1/openapi.yaml", params), nil, nil)
+	headers := c.buildHeaders(ctx, "", "")
+	headers.Set("Accept", "*/*")
+	if !c.config.OpenAPISpecAuth {
+		headers.Del("Authorization")
+	}
+
+	resp, err := c.doRequest(ctx, "GET", c.composeAPIURL("/resources/v1/openapi.yaml", params), headers, nil)
 	if err != nil {
 		return "", err
 	}
@@ -436,10 +1754,231 @@ func (c *Client) GetOpenAPISpec(ctx context.Context) (string, error) {
 	if err != nil {
 		return "", err
 	}
-
 	return string(body), nil
 }
 
+// ListQueriesOptions filters and paginates ListQueries.
+type ListQueriesOptions struct {
+	// Name keeps only queries whose name contains it.
+	Name string
+	// Type keeps only queries of the given type, e.g. AnalyticsQueryType.
+	Type string
+	// PageToken resumes listing from a previous QueriesPage.NextPageToken.
+	PageToken string
+	// Limit caps the number of queries per page. Zero means the API default.
+	Limit int
+
+	// NamePrefix keeps only queries whose name starts with it, and
+	// StartedBefore, if set, only queries started before it. Unlike the
+	// filters above they are not sent to the API.
+	NamePrefix    string
+	StartedBefore time.Time
+}
+
+// matches reports whether q passes the client-side filters.
+func (o ListQueriesOptions) matches(q QuerySummary) bool {
+	if o.Name != "" && !strings.Contains(q.Name, o.Name) {
+		return false
+	}
+	if o.Type != "" && q.Type != o.Type {
+		return false
+	}
+	if o.NamePrefix != "" && !strings.HasPrefix(q.Name, o.NamePrefix) {
+		return false
+	}
+	if !o.StartedBefore.IsZero() && (q.StartedAt.IsZero() || !q.StartedAt.Before(o.StartedBefore)) {
+		return false
+	}
+	return true
+}
+
+// QuerySummary is a query as returned by ListQueries.
+type QuerySummary struct {
+	ID     string
+	Name   string
+	Type   string
+	Status QueryStatus
+	// StartedAt is when the query was started, or zero if unknown.
+	StartedAt time.Time
+	// Raw holds the query object as returned by the API.
+	Raw map[string]interface{}
+}
+
+// QueriesPage is a page of queries. NextPageToken is empty on the last page.
+type QueriesPage struct {
+	Queries       []QuerySummary
+	NextPageToken string
+}
+
+// ListQueries returns a page of the queries of the project. Name and Type
+// are sent to the API, but all filters are also applied by the client to
+// each page, so they hold even where the API ignores a parameter. A page may
+// therefore hold fewer than Limit queries even when more pages follow.
+func (c *Client) ListQueries(ctx context.Context, opts ListQueriesOptions) (*QueriesPage, error) {
+	if opts.Limit < 0 {
+		return nil, fmt.Errorf("invalid limit %d", opts.Limit)
+	}
+
+	params := c.buildParams()
+	if opts.Name != "" {
+		params["name"] = opts.Name
+	}
+	if opts.Type != "" {
+		params["type"] = opts.Type
+	}
+	if opts.PageToken != "" {
+		params["page_token"] = opts.PageToken
+	}
+	if opts.Limit > 0 {
+		params["limit"] = strconv.Itoa(opts.Limit)
+	}
+
+	headers := c.buildHeaders(ctx, "", "")
+	resp, err := c.doRequest(ctx, "GET", c.composeAPIURL("/api/fq/v1/queries", params), headers, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if err := c.validateHTTPError(resp, http.StatusOK); err != nil {
+		return nil, err
+	}
+
+	var body interface{}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return nil, err
+	}
+
+	// The API answers with a bare array of queries; a paginated answer wraps
+	// it in an object along with the next page token.
+	page := &QueriesPage{}
+	var items []interface{}
+	switch v := body.(type) {
+	case []interface{}:
+		items = v
+	case map[string]interface{}:
+		var ok bool
+		if items, ok = v["queries"].([]interface{}); !ok && v["queries"] != nil {
+			return nil, fmt.Errorf("unexpected queries format")
+		}
+		page.NextPageToken, _ = v["next_page_token"].(string)
+	default:
+		return nil, fmt.Errorf("unexpected queries format")
+	}
+
+	page.Queries = make([]QuerySummary, 0, len(items))
+	for _, item := range items {
+		raw, ok := item.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("unexpected query format")
+		}
+		q := QuerySummary{Raw: raw}
+		q.ID, _ = raw["id"].(string)
+		q.Name, _ = raw["name"].(string)
+		q.Type, _ = raw["type"].(string)
+		q.Status = queryStatus(raw)
+		if meta, ok := raw["meta"].(map[string]interface{}); ok {
+			if startedAt, ok := meta["started_at"].(string); ok {
+				q.StartedAt, _ = time.Parse(time.RFC3339, startedAt)
+			}
+		}
+		if opts.matches(q) {
+			page.Queries = append(page.Queries, q)
+		}
+	}
+	return page, nil
+}
+
+// DeleteQueriesOption configures DeleteQueriesMatching.
+type DeleteQueriesOption func(*deleteQueriesConfig)
+
+type deleteQueriesConfig struct {
+	dryRun          bool
+	allowUnfiltered bool
+}
+
+// WithDryRun makes DeleteQueriesMatching delete nothing and count the
+// queries it would delete. ListQueriesMatching lists them.
+func WithDryRun() DeleteQueriesOption {
+	return func(cfg *deleteQueriesConfig) {
+		cfg.dryRun = true
+	}
+}
+
+// WithUnfilteredDelete permits DeleteQueriesMatching a filter without Name,
+// NamePrefix, Type or StartedBefore, which matches every query of the project.
+func WithUnfilteredDelete() DeleteQueriesOption {
+	return func(cfg *deleteQueriesConfig) {
+		cfg.allowUnfiltered = true
+	}
+}
+
+// ErrUnfilteredDelete is returned by DeleteQueriesMatching for a filter that
+// would match every query, unless WithUnfilteredDelete is given.
+var ErrUnfilteredDelete = errors.New("refusing to delete all queries without a filter")
+
+// ListQueriesMatching returns every query of the project that matches filter,
+// following all pages from filter.PageToken on.
+func (c *Client) ListQueriesMatching(ctx context.Context, filter ListQueriesOptions) ([]QuerySummary, error) {
+	var matched []QuerySummary
+	for {
+		page, err := c.ListQueries(ctx, filter)
+		if err != nil {
+			return nil, err
+		}
+		matched = append(matched, page.Queries...)
+		if page.NextPageToken == "" {
+			return matched, nil
+		}
+		filter.PageToken = page.NextPageToken
+	}
+}
+
+// DeleteQueriesMatching deletes every query of the project that matches
+// filter, as listed by ListQueriesMatching, and returns how many it deleted;
+// with WithDryRun, how many it would delete. Failing deletions don't stop the
+// others; their errors are joined into the returned error and they aren't
+// counted.
+func (c *Client) DeleteQueriesMatching(ctx context.Context, filter ListQueriesOptions, opts ...DeleteQueriesOption) (deleted int, err error) {
+	var cfg deleteQueriesConfig
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	if filter.Name == "" && filter.NamePrefix == "" && filter.Type == "" && filter.StartedBefore.IsZero() && !cfg.allowUnfiltered {
+		return 0, ErrUnfilteredDelete
+	}
+
+	// Collect all matches first so that deleting doesn't shift the pages.
+	matched, err := c.ListQueriesMatching(ctx, filter)
+	if err != nil {
+		return 0, err
+	}
+	if cfg.dryRun {
+		return len(matched), nil
+	}
+
+	var errs []error
+	for _, q := range matched {
+		if err := c.DeleteQuery(ctx, q.ID, "", ""); err != nil {
+			if ctx.Err() != nil {
+				return deleted, ctx.Err()
+			}
+			errs = append(errs, fmt.Errorf("delete query %s: %w", q.ID, err))
+			continue
+		}
+		deleted++
+	}
+	return deleted, errors.Join(errs...)
+}
+
+// CheckProjectAccess checks that the configured project exists and the token
+// may use it, by listing at most one query. YQ itself can't list the projects
+// (folders) a token has access to; that is done with the Resource Manager API.
+func (c *Client) CheckProjectAccess(ctx context.Context) error {
+	_, err := c.ListQueries(ctx, ListQueriesOptions{Limit: 1})
+	return err
+}
+
 // ComposeQueryWebLink returns a web link to a query in the YQ web interface.
 func (c *Client) ComposeQueryWebLink(queryID string) string {
 	return c.composeWebURL(fmt.Sprintf("/folders/%s/ide/queries/%s", c.config.Project, queryID))
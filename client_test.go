@@ -0,0 +1,2613 @@
+package yq
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func newTestClient(t *testing.T, handler http.Handler, config ClientConfig, opts ...Option) *Client {
+	t.Helper()
+	srv := httptest.NewServer(handler)
+	t.Cleanup(srv.Close)
+
+	config.Endpoint = srv.URL
+	if config.Token == "" {
+		config.Token = "test-token"
+	}
+	if config.Project == "" {
+		config.Project = "test-project"
+	}
+	c := NewClient(config, append([]Option{WithRetryPolicy(RetryPolicy{})}, opts...)...)
+	t.Cleanup(func() { c.Close() })
+	return c
+}
+
+// blockingCreate answers creates with q1 once release is closed, counting
+// them in calls and signalling arrived as each reaches the server.
+func blockingCreate(calls *int32, arrived chan<- struct{}, release <-chan struct{}) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(calls, 1)
+		select {
+		case arrived <- struct{}{}:
+		default:
+		}
+		<-release
+		w.Write([]byte(`{"id":"q1"}`))
+	}
+}
+
+func TestDedupeCreatesConcurrent(t *testing.T) {
+	var calls int32
+	arrived := make(chan struct{}, 2)
+	release := make(chan struct{})
+	c := newTestClient(t, blockingCreate(&calls, arrived, release), ClientConfig{DedupeCreates: true})
+
+	var wg sync.WaitGroup
+	ids := make([]string, 2)
+	errs := make([]error, 2)
+	create := func(i int) {
+		defer wg.Done()
+		ids[i], errs[i] = c.CreateQuery(context.Background(), "select 1", AnalyticsQueryType, "", "", "key", "")
+	}
+	wg.Add(2)
+	go create(0)
+	// The shared create is registered before its request is sent, so the
+	// second caller joins it while the server still holds the first request.
+	<-arrived
+	go create(1)
+	close(release)
+	wg.Wait()
+
+	for i := range ids {
+		if errs[i] != nil || ids[i] != "q1" {
+			t.Errorf("create %d = %q, %v; want q1", i, ids[i], errs[i])
+		}
+	}
+	if n := atomic.LoadInt32(&calls); n != 1 {
+		t.Errorf("got %d HTTP calls, want 1", n)
+	}
+}
+
+func TestDedupeCreatesFirstCallerCancelled(t *testing.T) {
+	var calls int32
+	arrived := make(chan struct{}, 2)
+	release := make(chan struct{})
+	c := newTestClient(t, blockingCreate(&calls, arrived, release), ClientConfig{DedupeCreates: true})
+
+	firstCtx, cancelFirst := context.WithCancel(context.Background())
+	firstErr := make(chan error, 1)
+	go func() {
+		_, err := c.CreateQuery(firstCtx, "select 1", AnalyticsQueryType, "", "", "key", "")
+		firstErr <- err
+	}()
+	<-arrived
+
+	cancelFirst()
+	if err := <-firstErr; err != context.Canceled {
+		t.Errorf("first create error = %v, want context.Canceled", err)
+	}
+
+	type result struct {
+		id  string
+		err error
+	}
+	second := make(chan result, 1)
+	go func() {
+		id, err := c.CreateQuery(context.Background(), "select 1", AnalyticsQueryType, "", "", "key", "")
+		second <- result{id, err}
+	}()
+	close(release)
+	if res := <-second; res.err != nil || res.id != "q1" {
+		t.Errorf("second create = %q, %v, want q1", res.id, res.err)
+	}
+	if n := atomic.LoadInt32(&calls); n != 1 {
+		t.Errorf("got %d HTTP calls, want 1", n)
+	}
+}
+
+func TestDedupeCreatesDisabled(t *testing.T) {
+	var calls int32
+	c := newTestClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		w.Write([]byte(`{"id":"q1"}`))
+	}), ClientConfig{})
+
+	for i := 0; i < 2; i++ {
+		if _, err := c.CreateQuery(context.Background(), "select 1", AnalyticsQueryType, "", "", "key", ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := atomic.LoadInt32(&calls); n != 2 {
+		t.Errorf("got %d HTTP calls, want 2", n)
+	}
+}
+
+// dropConnection makes the client see a transport error.
+func dropConnection(t *testing.T, w http.ResponseWriter) {
+	conn, _, err := w.(http.Hijacker).Hijack()
+	if err != nil {
+		t.Fatalf("hijack: %v", err)
+	}
+	conn.Close()
+}
+
+func fastPoll(time.Duration) time.Duration { return time.Millisecond }
+
+func TestWaitUsesPollRetryPolicy(t *testing.T) {
+	// Four transport failures in a row exceed MaxPollFailures, so only the
+	// poll retry policy can get the wait through them.
+	var calls int32
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&calls, 1) <= 4 {
+			dropConnection(t, w)
+			return
+		}
+		w.Write([]byte(`{"status":"COMPLETED"}`))
+	})
+
+	c := newTestClient(t, handler, ClientConfig{
+		PollInterval:    fastPoll,
+		PollRetryPolicy: &RetryPolicy{MaxRetries: 5, BaseDelay: time.Millisecond},
+	})
+	status, err := c.WaitQueryToComplete(context.Background(), "q1", 0, false)
+	if err != nil || status != StatusCompleted {
+		t.Fatalf("WaitQueryToComplete = %q, %v; want COMPLETED", status, err)
+	}
+
+	atomic.StoreInt32(&calls, 0)
+	c = newTestClient(t, handler, ClientConfig{
+		PollInterval: fastPoll,
+		RetryPolicy:  &RetryPolicy{MaxRetries: 5, BaseDelay: time.Millisecond},
+	})
+	if _, err := c.WaitQueryToComplete(context.Background(), "q1", 0, false); err == nil {
+		t.Fatal("WaitQueryToComplete succeeded; the client retry policy must not apply to polls")
+	}
+}
+
+func TestFollowResultSetToleratesPollFailure(t *testing.T) {
+	var polls int32
+	c := newTestClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/fq/v1/queries/q1/status":
+			if atomic.AddInt32(&polls, 1) == 1 {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+			w.Write([]byte(`{"status":"COMPLETED"}`))
+		case "/api/fq/v1/queries/q1/results/0":
+			w.Write([]byte(`{"columns":[{"name":"n","type":"Int32"}],"rows":[[1],[2]]}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}), ClientConfig{PollInterval: fastPoll})
+
+	var rows []Row
+	err := c.FollowResultSet(context.Background(), "q1", 0, func(row Row) error {
+		rows = append(rows, row)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("FollowResultSet: %v", err)
+	}
+	if len(rows) != 2 {
+		t.Errorf("got %d rows, want 2", len(rows))
+	}
+}
+
+func TestFollowResultSetFailsOnPermanentPageError(t *testing.T) {
+	c := newTestClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/fq/v1/queries/q1/status":
+			w.Write([]byte(`{"status":"RUNNING"}`))
+		case "/api/fq/v1/queries/q1/results/0":
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte(`{"status":"PERMISSION_DENIED","message":"denied"}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}), ClientConfig{PollInterval: fastPoll})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	err := c.FollowResultSet(ctx, "q1", 0, func(Row) error { return nil })
+	var yqErr *YQError
+	if !errors.As(err, &yqErr) || yqErr.StatusCode != http.StatusForbidden {
+		t.Fatalf("FollowResultSet error = %v, want a 403 *YQError", err)
+	}
+}
+
+func TestFollowResultSetToleratesTransientPageError(t *testing.T) {
+	var pages int32
+	var status atomic.Value
+	status.Store("RUNNING")
+	c := newTestClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/fq/v1/queries/q1/status":
+			w.Write([]byte(`{"status":"` + status.Load().(string) + `"}`))
+		case "/api/fq/v1/queries/q1/results/0":
+			if atomic.AddInt32(&pages, 1) == 1 {
+				status.Store("COMPLETED")
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+			w.Write([]byte(`{"columns":[{"name":"n","type":"Int32"}],"rows":[[1]]}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}), ClientConfig{PollInterval: fastPoll})
+
+	var n int
+	if err := c.FollowResultSet(context.Background(), "q1", 0, func(Row) error { n++; return nil }); err != nil {
+		t.Fatalf("FollowResultSet: %v", err)
+	}
+	if n != 1 {
+		t.Errorf("got %d rows, want 1", n)
+	}
+}
+
+func newQueriesServer(t *testing.T) (http.Handler, *sync.Map) {
+	var deleted sync.Map
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodGet && r.URL.Path == "/api/fq/v1/queries" && r.URL.Query().Get("page_token") == "":
+			w.Write([]byte(`{"queries":[` +
+				`{"id":"a","name":"test-1","type":"ANALYTICS","meta":{"started_at":"2020-01-01T00:00:00Z"}},` +
+				`{"id":"b","name":"prod","type":"ANALYTICS","meta":{"started_at":"2020-01-01T00:00:00Z"}}],"next_page_token":"p2"}`))
+		case r.Method == http.MethodGet && r.URL.Path == "/api/fq/v1/queries":
+			w.Write([]byte(`[` +
+				`{"id":"c","name":"test-2","type":"STREAMING","meta":{"started_at":"2020-01-02T00:00:00Z"}},` +
+				`{"id":"d","name":"test-3","type":"ANALYTICS","meta":{"started_at":"2030-01-01T00:00:00Z"}}]`))
+		case r.Method == http.MethodDelete:
+			deleted.Store(r.URL.Path, true)
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			http.NotFound(w, r)
+		}
+	}), &deleted
+}
+
+func queryIDs(queries []QuerySummary) []string {
+	ids := make([]string, len(queries))
+	for i, q := range queries {
+		ids[i] = q.ID
+	}
+	return ids
+}
+
+func TestDeleteQueriesMatchingDryRun(t *testing.T) {
+	handler, deleted := newQueriesServer(t)
+	c := newTestClient(t, handler, ClientConfig{})
+
+	filter := ListQueriesOptions{NamePrefix: "test-", StartedBefore: time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)}
+	n, err := c.DeleteQueriesMatching(context.Background(), filter, WithDryRun())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 2 {
+		t.Errorf("dry run counted %d queries, want 2", n)
+	}
+	queries, err := c.ListQueriesMatching(context.Background(), filter)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := queryIDs(queries); len(got) != 2 || got[0] != "a" || got[1] != "c" {
+		t.Errorf("matched %v, want [a c]", got)
+	}
+	deleted.Range(func(k, _ interface{}) bool {
+		t.Errorf("dry run deleted %v", k)
+		return true
+	})
+}
+
+func TestDeleteQueriesMatching(t *testing.T) {
+	handler, deleted := newQueriesServer(t)
+	c := newTestClient(t, handler, ClientConfig{})
+
+	n, err := c.DeleteQueriesMatching(context.Background(), ListQueriesOptions{NamePrefix: "test-"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 3 {
+		t.Errorf("deleted %d queries, want 3", n)
+	}
+	for _, id := range []string{"a", "c", "d"} {
+		if _, ok := deleted.Load("/api/fq/v1/queries/" + id); !ok {
+			t.Errorf("query %s not deleted", id)
+		}
+	}
+	if _, ok := deleted.Load("/api/fq/v1/queries/b"); ok {
+		t.Error("query b deleted despite not matching")
+	}
+}
+
+func TestDeleteQueriesMatchingFiltersIgnoredParams(t *testing.T) {
+	// The test server ignores the name and type parameters, so only the
+	// client-side check keeps the other queries from being deleted.
+	handler, deleted := newQueriesServer(t)
+	c := newTestClient(t, handler, ClientConfig{})
+
+	n, err := c.DeleteQueriesMatching(context.Background(), ListQueriesOptions{Name: "test", Type: "ANALYTICS"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 2 {
+		t.Errorf("deleted %d queries, want 2", n)
+	}
+	for _, id := range []string{"a", "d"} {
+		if _, ok := deleted.Load("/api/fq/v1/queries/" + id); !ok {
+			t.Errorf("query %s not deleted", id)
+		}
+	}
+	for _, id := range []string{"b", "c"} {
+		if _, ok := deleted.Load("/api/fq/v1/queries/" + id); ok {
+			t.Errorf("query %s deleted despite not matching", id)
+		}
+	}
+}
+
+func TestDeleteQueriesMatchingRequiresFilter(t *testing.T) {
+	handler, deleted := newQueriesServer(t)
+	c := newTestClient(t, handler, ClientConfig{})
+
+	if _, err := c.DeleteQueriesMatching(context.Background(), ListQueriesOptions{}); !errors.Is(err, ErrUnfilteredDelete) {
+		t.Fatalf("error = %v, want ErrUnfilteredDelete", err)
+	}
+	n, err := c.DeleteQueriesMatching(context.Background(), ListQueriesOptions{}, WithUnfilteredDelete(), WithDryRun())
+	if err != nil || n != 4 {
+		t.Fatalf("unfiltered dry run = %d, %v; want 4 queries", n, err)
+	}
+	deleted.Range(func(k, _ interface{}) bool {
+		t.Errorf("deleted %v", k)
+		return true
+	})
+}
+
+func TestCorrelationIDPropagation(t *testing.T) {
+	var sent sync.Map
+	c := newTestClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sent.Store(r.URL.Path, r.Header.Get("x-request-id"))
+		switch r.URL.Path {
+		case "/api/fq/v1/queries/q1/status":
+			w.Write([]byte(`{"status":"FAILED"}`))
+		case "/api/fq/v1/queries/q1":
+			w.Write([]byte(`{"id":"q1","status":"FAILED","issues":[{"message":"syntax error"}]}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"status":"NOT_FOUND","message":"no such query"}`))
+		}
+	}), ClientConfig{PollInterval: fastPoll})
+	ctx := WithCorrelationID(context.Background(), "corr-1")
+
+	_, err := c.AttachAndCollect(ctx, "q1", WaitPolicy{})
+	var issuesErr *IssuesError
+	if !errors.As(err, &issuesErr) || issuesErr.RequestID != "corr-1" {
+		t.Errorf("AttachAndCollect error = %v, want *IssuesError with RequestID corr-1", err)
+	}
+
+	_, err = c.WaitQueryToSucceed(ctx, "q1", 0, false)
+	if !errors.As(err, &issuesErr) || issuesErr.RequestID != "corr-1" {
+		t.Errorf("WaitQueryToSucceed error = %v, want *IssuesError with RequestID corr-1", err)
+	}
+
+	_, err = c.GetQueryStatus(ctx, "missing", "")
+	var yqErr *YQError
+	if !errors.As(err, &yqErr) || yqErr.RequestID != "corr-1" {
+		t.Errorf("GetQueryStatus error = %v, want *YQError with RequestID corr-1", err)
+	}
+
+	if id, _ := sent.Load("/api/fq/v1/queries/q1/status"); id != "corr-1" {
+		t.Errorf("x-request-id = %v, want corr-1", id)
+	}
+	if _, err := c.GetQueryStatus(ctx, "missing", "explicit"); !errors.As(err, &yqErr) || yqErr.RequestID != "explicit" {
+		t.Errorf("explicit request ID not used: %v", err)
+	}
+}
+
+// rowsHandler serves a single Int64 column result set of n rows, honouring
+// the offset and limit parameters, and counts the page requests it gets.
+func rowsHandler(n int, requests *int32) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if requests != nil {
+			atomic.AddInt32(requests, 1)
+		}
+		offset, _ := strconv.Atoi(r.URL.Query().Get("offset"))
+		limit, err := strconv.Atoi(r.URL.Query().Get("limit"))
+		if err != nil || limit == 0 {
+			limit = defaultPageLimit
+		}
+		rows := make([]string, 0, limit)
+		for i := offset; i < n && len(rows) < limit; i++ {
+			rows = append(rows, "["+strconv.Itoa(i)+"]")
+		}
+		w.Write([]byte(`{"columns":[{"name":"n","type":"Int64"}],"rows":[` + strings.Join(rows, ",") + `]}`))
+	}
+}
+
+func TestGetResultSetPageInfersHasMore(t *testing.T) {
+	tests := []struct {
+		rows, limit, pages int
+	}{
+		{rows: 0, limit: 10, pages: 1},
+		{rows: 25, limit: 10, pages: 3},
+		{rows: 20, limit: 10, pages: 3},
+		{rows: 5, limit: 0, pages: 1},
+	}
+	for _, tt := range tests {
+		c := newTestClient(t, rowsHandler(tt.rows, nil), ClientConfig{})
+		var got, pages int
+		for offset := 0; ; pages++ {
+			page, err := c.GetResultSetPage(context.Background(), "q1", 0, offset, tt.limit)
+			if err != nil {
+				t.Fatalf("GetResultSetPage: %v", err)
+			}
+			got += len(page.Rows)
+			offset += len(page.Rows)
+			if !page.HasMore {
+				if page.Total != tt.rows {
+					t.Errorf("rows=%d limit=%d: Total = %d, want %d", tt.rows, tt.limit, page.Total, tt.rows)
+				}
+				pages++
+				break
+			}
+			if page.Total != -1 {
+				t.Errorf("rows=%d limit=%d: Total = %d before the last page", tt.rows, tt.limit, page.Total)
+			}
+		}
+		if got != tt.rows || pages != tt.pages {
+			t.Errorf("rows=%d limit=%d: got %d rows in %d pages, want %d in %d", tt.rows, tt.limit, got, pages, tt.rows, tt.pages)
+		}
+	}
+}
+
+func TestApproxBytesAccumulatedWhilePaging(t *testing.T) {
+	var requests int32
+	c := newTestClient(t, rowsHandler(2500, &requests), ClientConfig{})
+
+	r, err := c.fetchResultSet(context.Background(), "q1", 0, nil)
+	if err != nil {
+		t.Fatalf("fetchResultSet: %v", err)
+	}
+	if n := atomic.LoadInt32(&requests); n != 3 {
+		t.Errorf("fetched %d pages, want 3", n)
+	}
+	size := r.ApproxBytes()
+	if whole := approxSize(r.RawResults()["rows"]); size < whole-3*24 || size > whole+3*24 {
+		t.Errorf("ApproxBytes = %d, want about %d", size, whole)
+	}
+	if r.approxBytes != size {
+		t.Errorf("ApproxBytes = %d was computed again, want the %d summed up while paging", size, r.approxBytes)
+	}
+
+	p, err := r.Project([]string{"n"})
+	if err != nil {
+		t.Fatalf("Project: %v", err)
+	}
+	if got := p.ApproxBytes(); got != size {
+		t.Errorf("projected ApproxBytes = %d, want %d", got, size)
+	}
+}
+
+// fakeYQ simulates the lifecycle of a single query: it is created by a POST,
+// reports RUNNING for the first runningPolls status polls and then finishes
+// with the given status, serving resultSets once completed.
+type fakeYQ struct {
+	runningPolls int
+	finalStatus  string
+	issues       string
+	resultSets   []string
+
+	mu      sync.Mutex
+	created bool
+	polls   int
+	text    string
+}
+
+func (f *fakeYQ) status() string {
+	if f.polls <= f.runningPolls {
+		return "RUNNING"
+	}
+	return f.finalStatus
+}
+
+func (f *fakeYQ) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	switch path := r.URL.Path; {
+	case r.Method == http.MethodPost && path == "/api/fq/v1/queries":
+		var body map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&body)
+		f.text, _ = body["text"].(string)
+		f.created = true
+		w.Write([]byte(`{"id":"q1"}`))
+	case !f.created:
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(`{"message":"query not found"}`))
+	case path == "/api/fq/v1/queries/q1/status":
+		f.polls++
+		w.Write([]byte(`{"status":"` + f.status() + `"}`))
+	case path == "/api/fq/v1/queries/q1":
+		sets := make([]string, len(f.resultSets))
+		for i := range sets {
+			sets[i] = `{"rows_count":1}`
+		}
+		issues := f.issues
+		if issues == "" {
+			issues = "[]"
+		}
+		w.Write([]byte(`{"id":"q1","status":"` + f.status() + `","issues":` + issues +
+			`,"result_sets":[` + strings.Join(sets, ",") + `]}`))
+	case strings.HasPrefix(path, "/api/fq/v1/queries/q1/results/"):
+		idx, err := strconv.Atoi(strings.TrimPrefix(path, "/api/fq/v1/queries/q1/results/"))
+		if err != nil || idx >= len(f.resultSets) || f.status() != "COMPLETED" {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"message":"no such result set"}`))
+			return
+		}
+		w.Write([]byte(f.resultSets[idx]))
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func TestRunAndCollect(t *testing.T) {
+	fake := &fakeYQ{
+		runningPolls: 2,
+		finalStatus:  "COMPLETED",
+		resultSets: []string{
+			`{"columns":[{"name":"n","type":"Int32"},{"name":"s","type":"String"}],"rows":[[1,"YQ=="]]}`,
+			`{"columns":[{"name":"ok","type":"Bool"}],"rows":[[true],[false]]}`,
+		},
+	}
+	c := newTestClient(t, fake, ClientConfig{PollInterval: fastPoll})
+
+	results, err := c.RunAndCollect(context.Background(), CreateQueryRequest{Text: "select 1"}, WaitPolicy{})
+	if err != nil {
+		t.Fatalf("RunAndCollect: %v", err)
+	}
+	fake.mu.Lock()
+	text, polls := fake.text, fake.polls
+	fake.mu.Unlock()
+	if text != "select 1" {
+		t.Errorf("created query text = %q", text)
+	}
+	if polls <= fake.runningPolls {
+		t.Errorf("collected after %d polls, before the query completed", polls)
+	}
+	if len(results) != 2 {
+		t.Fatalf("got %d result sets, want 2", len(results))
+	}
+	if got := results[0].Cell(0, 1); got != "a" {
+		t.Errorf("result set 0 cell (0, 1) = %#v, want \"a\"", got)
+	}
+	if got := results[1].Results()["rows"].([][]interface{}); len(got) != 2 || got[1][0] != false {
+		t.Errorf("result set 1 rows = %v", got)
+	}
+}
+
+func TestRunAndCollectFailedQuery(t *testing.T) {
+	fake := &fakeYQ{
+		finalStatus: "FAILED",
+		issues:      `[{"message":"Unknown column x","severity":1}]`,
+	}
+	c := newTestClient(t, fake, ClientConfig{PollInterval: fastPoll})
+
+	_, err := c.RunAndCollect(context.Background(), CreateQueryRequest{Text: "select x"}, WaitPolicy{})
+	var issuesErr *IssuesError
+	if !errors.As(err, &issuesErr) {
+		t.Fatalf("RunAndCollect error = %v, want *IssuesError", err)
+	}
+	if issuesErr.QueryID != "q1" || issuesErr.Status != StatusFailed ||
+		len(issuesErr.Issues) != 1 || issuesErr.Issues[0].Message != "Unknown column x" {
+		t.Errorf("IssuesError = %+v", issuesErr)
+	}
+}
+
+func TestWaitQueryToSucceedE(t *testing.T) {
+	fake := &fakeYQ{
+		runningPolls: 1,
+		finalStatus:  "COMPLETED",
+		resultSets:   []string{`{"columns":[{"name":"s","type":"String"}],"rows":[["YQ=="],["Yg=="]]}`},
+		created:      true,
+	}
+	c := newTestClient(t, fake, ClientConfig{PollInterval: fastPoll})
+
+	results, err := c.WaitQueryToSucceedE(context.Background(), "q1", 0, false)
+	if err != nil {
+		t.Fatalf("WaitQueryToSucceedE: %v", err)
+	}
+	if len(results) != 1 || !reflect.DeepEqual(results[0].ToTable(), [][]interface{}{{"a"}, {"b"}}) {
+		t.Errorf("WaitQueryToSucceedE = %v", results)
+	}
+
+	fake = &fakeYQ{finalStatus: "ABORTED", issues: `[{"message":"stopped"}]`, created: true}
+	c = newTestClient(t, fake, ClientConfig{PollInterval: fastPoll})
+	var issuesErr *IssuesError
+	if _, err := c.WaitQueryToSucceedE(context.Background(), "q1", 0, false); !errors.As(err, &issuesErr) || issuesErr.Status != StatusAborted {
+		t.Errorf("WaitQueryToSucceedE error = %v, want an ABORTED *IssuesError", err)
+	}
+}
+
+func TestMaxResponseBytes(t *testing.T) {
+	big := `{"id":"q1","text":"` + strings.Repeat("x", 4096) + `"}`
+	c := newTestClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/resources/v1/openapi.yaml", "/api/fq/v1/queries/big":
+			w.Write([]byte(big))
+		default:
+			w.Write([]byte(`{"id":"q1"}`))
+		}
+	}), ClientConfig{MaxResponseBytes: 1024})
+	ctx := context.Background()
+
+	if _, err := c.GetOpenAPISpec(ctx); !errors.Is(err, ErrResponseTooLarge) {
+		t.Errorf("GetOpenAPISpec error = %v, want ErrResponseTooLarge", err)
+	}
+	if _, err := c.GetQuery(ctx, "big", ""); !errors.Is(err, ErrResponseTooLarge) {
+		t.Errorf("GetQuery error = %v, want ErrResponseTooLarge", err)
+	}
+	if query, err := c.GetQuery(ctx, "small", ""); err != nil || query["id"] != "q1" {
+		t.Errorf("GetQuery = %v, %v; want a response under the limit to decode", query, err)
+	}
+}
+
+func TestWaitStopsQueryAfterParentDeadline(t *testing.T) {
+	stops := make(chan string, 1)
+	c := newTestClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/fq/v1/queries/q1/status":
+			w.Write([]byte(`{"status":"RUNNING"}`))
+		case "/api/fq/v1/queries/q1/stop":
+			stops <- r.Header.Get("x-request-id")
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			http.NotFound(w, r)
+		}
+	}), ClientConfig{PollInterval: fastPoll})
+
+	ctx, cancel := context.WithTimeout(WithCorrelationID(context.Background(), "corr-1"), -time.Second)
+	defer cancel()
+	_, err := c.WaitQueryToComplete(ctx, "q1", 0, true)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("WaitQueryToComplete error = %v, want context.DeadlineExceeded", err)
+	}
+
+	select {
+	case id := <-stops:
+		if id != "corr-1" {
+			t.Errorf("stop request x-request-id = %q, want the caller's corr-1", id)
+		}
+	default:
+		t.Fatal("no stop request was sent")
+	}
+}
+
+func TestCloseAbortsInFlightRequests(t *testing.T) {
+	started := make(chan struct{})
+	c := newTestClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-r.Context().Done()
+	}), ClientConfig{})
+
+	errc := make(chan error, 1)
+	go func() {
+		_, err := c.GetQuery(context.Background(), "q1", "")
+		errc <- err
+	}()
+	<-started
+	if err := c.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	select {
+	case err := <-errc:
+		if !errors.Is(err, ErrClientClosed) {
+			t.Errorf("in-flight GetQuery error = %v, want ErrClientClosed", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("in-flight request not aborted by Close")
+	}
+	if _, err := c.GetQuery(context.Background(), "q1", ""); !errors.Is(err, ErrClientClosed) {
+		t.Errorf("GetQuery after Close error = %v, want ErrClientClosed", err)
+	}
+}
+
+func TestWaitStopsQueryWhenContextDeadlineBeatsExecutionTimeout(t *testing.T) {
+	var stops int32
+	c := newTestClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/fq/v1/queries/q1/status":
+			w.Write([]byte(`{"status":"RUNNING"}`))
+		case "/api/fq/v1/queries/q1/stop":
+			atomic.AddInt32(&stops, 1)
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			http.NotFound(w, r)
+		}
+	}), ClientConfig{PollInterval: fastPoll})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err := c.WaitQueryToComplete(ctx, "q1", time.Hour, true)
+	var waitErr *WaitError
+	if !errors.As(err, &waitErr) || !errors.Is(err, context.DeadlineExceeded) || waitErr.LastStatus != StatusRunning {
+		t.Errorf("WaitQueryToComplete error = %v, want a *WaitError for the deadline after RUNNING", err)
+	}
+	if n := atomic.LoadInt32(&stops); n != 1 {
+		t.Errorf("got %d stop requests, want 1", n)
+	}
+
+	ctx, cancel = context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+	if _, err := c.WaitQueryToComplete(ctx, "q1", time.Hour, true); !errors.Is(err, context.Canceled) {
+		t.Errorf("WaitQueryToComplete error = %v, want context.Canceled", err)
+	}
+	if n := atomic.LoadInt32(&stops); n != 1 {
+		t.Errorf("a cancelled wait sent a stop request")
+	}
+}
+
+// stoppableQuery serves a query q1 with the given status that turns ABORTED
+// when stopped, and counts the stop requests.
+func stoppableQuery(status string, stops *int32) http.HandlerFunc {
+	var mu sync.Mutex
+	return func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		switch r.URL.Path {
+		case "/api/fq/v1/queries/q1/status":
+			w.Write([]byte(`{"status":"` + status + `"}`))
+		case "/api/fq/v1/queries/q1/stop":
+			atomic.AddInt32(stops, 1)
+			if QueryStatus(status).IsTerminal() {
+				w.WriteHeader(http.StatusBadRequest)
+				w.Write([]byte(`{"message":"query is not running"}`))
+				return
+			}
+			status = "ABORTED"
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			http.NotFound(w, r)
+		}
+	}
+}
+
+func TestEnsureStoppedAlreadyFinished(t *testing.T) {
+	for _, status := range []QueryStatus{StatusCompleted, StatusFailed, StatusAborted} {
+		var stops int32
+		c := newTestClient(t, stoppableQuery(string(status), &stops), ClientConfig{PollInterval: fastPoll})
+
+		got, err := c.EnsureStopped(context.Background(), "q1")
+		if err != nil || got != status {
+			t.Errorf("EnsureStopped = %s, %v; want %s", got, err, status)
+		}
+		if n := atomic.LoadInt32(&stops); n != 0 {
+			t.Errorf("%s query: got %d stop requests, want none", status, n)
+		}
+	}
+}
+
+func TestEnsureStoppedRunning(t *testing.T) {
+	var stops int32
+	c := newTestClient(t, stoppableQuery("RUNNING", &stops), ClientConfig{PollInterval: fastPoll})
+
+	got, err := c.EnsureStopped(context.Background(), "q1")
+	if err != nil || got != StatusAborted {
+		t.Errorf("EnsureStopped = %s, %v; want ABORTED", got, err)
+	}
+	if n := atomic.LoadInt32(&stops); n != 1 {
+		t.Errorf("got %d stop requests, want 1", n)
+	}
+}
+
+func TestStopQueryAcceptsBodies(t *testing.T) {
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+	}{
+		{"200 with body", func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(`{"status":"SUCCESS"}`))
+		}},
+		{"204 with body", func(w http.ResponseWriter, r *http.Request) {
+			conn, buf, err := w.(http.Hijacker).Hijack()
+			if err != nil {
+				t.Errorf("hijack: %v", err)
+				return
+			}
+			defer conn.Close()
+			buf.WriteString("HTTP/1.1 204 No Content\r\nContent-Length: 2\r\nConnection: close\r\n\r\n{}")
+			buf.Flush()
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newTestClient(t, tt.handler, ClientConfig{})
+			if err := c.StopQuery(context.Background(), "q1", "", ""); err != nil {
+				t.Errorf("StopQuery: %v", err)
+			}
+		})
+	}
+}
+
+func TestValidateHTTPErrorEmptyBody(t *testing.T) {
+	c := newTestClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusConflict)
+	}), ClientConfig{})
+
+	err := c.StopQuery(context.Background(), "q1", "", "")
+	var yqErr *YQError
+	if !errors.As(err, &yqErr) || yqErr.StatusCode != http.StatusConflict {
+		t.Fatalf("StopQuery error = %v, want a 409 *YQError", err)
+	}
+	if !strings.Contains(yqErr.Error(), "409") {
+		t.Errorf("error %q does not mention the status code", yqErr.Error())
+	}
+}
+
+func TestCreateQueryAcceptsAsyncCodes(t *testing.T) {
+	for _, code := range []int{http.StatusOK, http.StatusCreated, http.StatusAccepted} {
+		c := newTestClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(code)
+			w.Write([]byte(`{"id":"q1"}`))
+		}), ClientConfig{})
+
+		id, err := c.CreateQuery(context.Background(), "select 1", AnalyticsQueryType, "", "", "", "")
+		if err != nil || id != "q1" {
+			t.Errorf("%d: CreateQuery = %q, %v; want q1", code, id, err)
+		}
+	}
+
+	c := newTestClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}), ClientConfig{})
+	if _, err := c.CreateQuery(context.Background(), "select 1", AnalyticsQueryType, "", "", "", ""); err == nil {
+		t.Error("CreateQuery accepted a 204")
+	}
+}
+
+func TestRunQueryToWriter(t *testing.T) {
+	resultSets := []string{
+		`{"columns":[{"name":"n","type":"Int32"},{"name":"s","type":"String"}],"rows":[[1,"YQ=="],[2,"Yiw="]]}`,
+		`{"columns":[{"name":"other","type":"Int32"}],"rows":[[3]]}`,
+	}
+	tests := []struct {
+		format ExportFormat
+		want   string
+	}{
+		{ExportCSV, "n,s\n1,a\n2,\"b,\"\n"},
+		{ExportJSONL, "{\"n\":1,\"s\":\"a\"}\n{\"n\":2,\"s\":\"b,\"}\n"},
+	}
+	for _, tt := range tests {
+		fake := &fakeYQ{runningPolls: 1, finalStatus: "COMPLETED", resultSets: resultSets}
+		c := newTestClient(t, fake, ClientConfig{PollInterval: fastPoll})
+
+		var out strings.Builder
+		if err := c.RunQueryToWriter(context.Background(), CreateQueryRequest{Text: "select 1"}, tt.format, &out); err != nil {
+			t.Fatalf("%s: RunQueryToWriter: %v", tt.format, err)
+		}
+		if out.String() != tt.want {
+			t.Errorf("%s: wrote %q, want %q", tt.format, out.String(), tt.want)
+		}
+	}
+}
+
+func TestRunQueryToWriterFailedQuery(t *testing.T) {
+	fake := &fakeYQ{finalStatus: "FAILED", issues: `[{"message":"boom"}]`}
+	c := newTestClient(t, fake, ClientConfig{PollInterval: fastPoll})
+
+	var out strings.Builder
+	err := c.RunQueryToWriter(context.Background(), CreateQueryRequest{Text: "select 1"}, ExportCSV, &out)
+	var issuesErr *IssuesError
+	if !errors.As(err, &issuesErr) {
+		t.Errorf("RunQueryToWriter error = %v, want *IssuesError", err)
+	}
+	if out.Len() != 0 {
+		t.Errorf("wrote %q for a failed query", out.String())
+	}
+}
+
+func TestYQErrorRetryAfter(t *testing.T) {
+	date := time.Now().Add(time.Hour).UTC().Format(http.TimeFormat)
+	tests := []struct {
+		header string
+		ok     bool
+		min    time.Duration
+		max    time.Duration
+	}{
+		{header: "7", ok: true, min: 7 * time.Second, max: 7 * time.Second},
+		{header: date, ok: true, min: 59 * time.Minute, max: time.Hour},
+		{header: ""},
+		{header: "soon"},
+		{header: "-1"},
+	}
+	for _, tt := range tests {
+		c := newTestClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if tt.header != "" {
+				w.Header().Set("Retry-After", tt.header)
+			}
+			w.WriteHeader(http.StatusTooManyRequests)
+			w.Write([]byte(`{"status":"OVERLOADED","message":"slow down"}`))
+		}), ClientConfig{})
+
+		_, err := c.GetQuery(context.Background(), "q1", "")
+		var yqErr *YQError
+		if !errors.As(err, &yqErr) || yqErr.StatusCode != http.StatusTooManyRequests {
+			t.Fatalf("GetQuery error = %v, want a 429 *YQError", err)
+		}
+		d, ok := yqErr.RetryAfter()
+		if ok != tt.ok || d < tt.min || d > tt.max {
+			t.Errorf("Retry-After %q: RetryAfter() = %v, %v; want %v in [%v, %v]", tt.header, d, ok, tt.ok, tt.min, tt.max)
+		}
+	}
+}
+
+// tickingClock returns a clock that advances by step on every reading.
+func tickingClock(step time.Duration) func() time.Time {
+	var mu sync.Mutex
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	return func() time.Time {
+		mu.Lock()
+		defer mu.Unlock()
+		now = now.Add(step)
+		return now
+	}
+}
+
+func TestMaxFetchDuration(t *testing.T) {
+	config := ClientConfig{MaxFetchDuration: 90 * time.Second}
+	c := newTestClient(t, rowsHandler(2500, nil), config)
+	c.now = tickingClock(time.Minute)
+	if _, err := c.GetQueryResultSet(context.Background(), "q1", 0, true); !errors.Is(err, ErrFetchDurationExceeded) {
+		t.Errorf("GetQueryResultSet error = %v, want ErrFetchDurationExceeded", err)
+	}
+
+	var requests int32
+	config.TruncateOnMaxFetchDuration = true
+	c = newTestClient(t, rowsHandler(2500, &requests), config)
+	c.now = tickingClock(time.Minute)
+	r, err := c.GetResultSetsUnioned(context.Background(), "q1", 1)
+	if err != nil {
+		t.Fatalf("GetResultSetsUnioned: %v", err)
+	}
+	if !r.Truncated() || len(r.ToTable()) != 2000 || atomic.LoadInt32(&requests) != 2 {
+		t.Errorf("got truncated=%v with %d rows in %d pages, want a truncated 2000 rows in 2 pages",
+			r.Truncated(), len(r.ToTable()), atomic.LoadInt32(&requests))
+	}
+
+	c.now = tickingClock(time.Minute)
+	for _, rawFormat := range []bool{true, false} {
+		raw, err := c.GetQueryResultSet(context.Background(), "q1", 0, rawFormat)
+		if err != nil {
+			t.Fatalf("GetQueryResultSet: %v", err)
+		}
+		if len(raw) != 2 || raw["rows"] == nil || raw["columns"] == nil {
+			t.Errorf("GetQueryResultSet(rawFormat=%v) has %d keys, want only rows and columns", rawFormat, len(raw))
+		}
+	}
+
+	c = newTestClient(t, rowsHandler(2500, nil), ClientConfig{})
+	c.now = tickingClock(time.Hour)
+	if r, err := c.GetResultSetsUnioned(context.Background(), "q1", 1); err != nil || r.Truncated() {
+		t.Errorf("GetResultSetsUnioned without MaxFetchDuration: err = %v, truncated = %v", err, err == nil && r.Truncated())
+	}
+}
+
+func TestDebugDumpsRequestsAndRedactsToken(t *testing.T) {
+	var out bytes.Buffer
+	c := newTestClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"id":"q1"}`))
+	}), ClientConfig{Token: "secret-token", Debug: true, DebugWriter: &out})
+
+	ctx := WithRequestMetadata(context.Background(), map[string]string{"tenant": "acme"})
+	id, err := c.CreateQuery(ctx, "select 1", AnalyticsQueryType, "", "", "", "")
+	if err != nil || id != "q1" {
+		t.Fatalf("CreateQuery = %q, %v; want the response body still decoded", id, err)
+	}
+
+	dump := out.String()
+	for _, want := range []string{"> POST ", "/api/fq/v1/queries", "select 1", "(metadata tenant=acme)", "REDACTED", `{"id":"q1"}`} {
+		if !strings.Contains(dump, want) {
+			t.Errorf("debug output lacks %q:\n%s", want, dump)
+		}
+	}
+	if strings.Contains(dump, "secret-token") {
+		t.Errorf("debug output leaks the token:\n%s", dump)
+	}
+}
+
+func TestAttachAndCollect(t *testing.T) {
+	resultSets := []string{`{"columns":[{"name":"n","type":"Int32"}],"rows":[[1],[2]]}`}
+	tests := []struct {
+		name         string
+		runningPolls int
+		finalStatus  string
+		wantPolls    bool
+		wantErr      bool
+	}{
+		{name: "completed", runningPolls: -1, finalStatus: "COMPLETED"},
+		{name: "running", runningPolls: 2, finalStatus: "COMPLETED", wantPolls: true},
+		{name: "failed", runningPolls: -1, finalStatus: "FAILED", wantErr: true},
+		{name: "fails while attached", runningPolls: 1, finalStatus: "FAILED", wantPolls: true, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := &fakeYQ{
+				runningPolls: tt.runningPolls,
+				finalStatus:  tt.finalStatus,
+				issues:       `[{"message":"boom"}]`,
+				resultSets:   resultSets,
+				created:      true,
+			}
+			c := newTestClient(t, fake, ClientConfig{PollInterval: fastPoll})
+
+			results, err := c.AttachAndCollect(context.Background(), "q1", WaitPolicy{})
+			fake.mu.Lock()
+			polls := fake.polls
+			fake.mu.Unlock()
+			if (polls > 0) != tt.wantPolls {
+				t.Errorf("made %d status polls, want polling %v", polls, tt.wantPolls)
+			}
+			if tt.wantErr {
+				var issuesErr *IssuesError
+				if !errors.As(err, &issuesErr) || issuesErr.Status != StatusFailed || len(issuesErr.Issues) != 1 {
+					t.Errorf("AttachAndCollect error = %v, want *IssuesError for FAILED", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("AttachAndCollect: %v", err)
+			}
+			if len(results) != 1 || len(results[0].ToTable()) != 2 {
+				t.Errorf("got %d result sets, want 1 with 2 rows", len(results))
+			}
+		})
+	}
+}
+
+func TestAttachAndCollectUnknownQuery(t *testing.T) {
+	c := newTestClient(t, &fakeYQ{}, ClientConfig{PollInterval: fastPoll})
+	_, err := c.AttachAndCollect(context.Background(), "q1", WaitPolicy{})
+	var yqErr *YQError
+	if !errors.As(err, &yqErr) || yqErr.StatusCode != http.StatusNotFound {
+		t.Errorf("AttachAndCollect error = %v, want a 404 *YQError", err)
+	}
+}
+
+func writeQueryFiles(t *testing.T, files map[string]string) string {
+	t.Helper()
+	dir := t.TempDir()
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestCreateQueryFromFileIncludes(t *testing.T) {
+	dir := writeQueryFiles(t, map[string]string{
+		"main.sql":           "$x = 1;\n-- include: common/filters.sql\n  -- include: shared.sql\nselect $x;\n",
+		"common/filters.sql": "$y = 2;\n-- include: ../shared.sql\n",
+		"shared.sql":         "$z = 3;\n",
+	})
+	fake := &fakeYQ{}
+	c := newTestClient(t, fake, ClientConfig{})
+
+	id, err := c.CreateQueryFromFile(context.Background(), filepath.Join(dir, "main.sql"), CreateQueryRequest{Text: "ignored"})
+	if err != nil || id != "q1" {
+		t.Fatalf("CreateQueryFromFile = %q, %v", id, err)
+	}
+	fake.mu.Lock()
+	text := fake.text
+	fake.mu.Unlock()
+	if want := "$x = 1;\n$y = 2;\n$z = 3;\n$z = 3;\nselect $x;\n"; text != want {
+		t.Errorf("query text = %q, want %q", text, want)
+	}
+}
+
+func TestCreateQueryFromFileIncludeCycle(t *testing.T) {
+	dir := writeQueryFiles(t, map[string]string{
+		"a.sql": "-- include: b.sql\n",
+		"b.sql": "select 1;\n-- include: a.sql\n",
+	})
+	fake := &fakeYQ{}
+	c := newTestClient(t, fake, ClientConfig{})
+
+	_, err := c.CreateQueryFromFile(context.Background(), filepath.Join(dir, "a.sql"), CreateQueryRequest{})
+	if err == nil || !strings.Contains(err.Error(), "include cycle") {
+		t.Errorf("CreateQueryFromFile error = %v, want an include cycle", err)
+	}
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+	if fake.created {
+		t.Error("query created despite the include cycle")
+	}
+}
+
+func TestWaitQueryToSucceedResultSets(t *testing.T) {
+	tests := []struct {
+		name       string
+		resultSets string
+		require    bool
+		want       int
+		wantErr    string
+	}{
+		{name: "DDL without result_sets"},
+		{name: "null", resultSets: `,"result_sets":null`},
+		{name: "empty", resultSets: `,"result_sets":[]`},
+		{name: "two", resultSets: `,"result_sets":[{},{}]`, want: 2},
+		{name: "malformed", resultSets: `,"result_sets":"oops"`, wantErr: "unexpected result_sets format"},
+		{name: "required but missing", require: true, wantErr: ErrNoResultSets.Error()},
+		{name: "required but null", resultSets: `,"result_sets":null`, require: true, wantErr: ErrNoResultSets.Error()},
+		{name: "required and empty", resultSets: `,"result_sets":[]`, require: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newTestClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				switch r.URL.Path {
+				case "/api/fq/v1/queries/q1/status":
+					w.Write([]byte(`{"status":"COMPLETED"}`))
+				case "/api/fq/v1/queries/q1":
+					w.Write([]byte(`{"id":"q1","status":"COMPLETED"` + tt.resultSets + `}`))
+				default:
+					http.NotFound(w, r)
+				}
+			}), ClientConfig{PollInterval: fastPoll, RequireResultSets: tt.require})
+
+			n, err := c.WaitQueryToSucceed(context.Background(), "q1", 0, false)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("WaitQueryToSucceed = %d, %v; want an error containing %q", n, err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil || n != tt.want {
+				t.Errorf("WaitQueryToSucceed = %d, %v; want %d", n, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestGetResultSetsSubset(t *testing.T) {
+	fake := &fakeYQ{
+		runningPolls: -1,
+		finalStatus:  "COMPLETED",
+		created:      true,
+		resultSets: []string{
+			`{"columns":[{"name":"set","type":"Int32"}],"rows":[[0]]}`,
+			`{"columns":[{"name":"set","type":"Int32"}],"rows":[[1]]}`,
+			`{"columns":[{"name":"set","type":"Int32"}],"rows":[[2]]}`,
+		},
+	}
+	var fetched []string
+	var mu sync.Mutex
+	c := newTestClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.Contains(r.URL.Path, "/results/") {
+			mu.Lock()
+			fetched = append(fetched, r.URL.Path)
+			mu.Unlock()
+		}
+		fake.ServeHTTP(w, r)
+	}), ClientConfig{})
+
+	results, err := c.GetResultSets(context.Background(), "q1", []int{2, 0})
+	if err != nil {
+		t.Fatalf("GetResultSets: %v", err)
+	}
+	if len(results) != 2 || results[0].Cell(0, 0) != float64(2) || results[1].Cell(0, 0) != float64(0) {
+		t.Errorf("got result sets %v, want sets 2 and 0 in that order", results)
+	}
+	mu.Lock()
+	if len(fetched) != 2 {
+		t.Errorf("fetched %v, want only the two requested sets", fetched)
+	}
+	mu.Unlock()
+
+	if _, err := c.GetResultSets(context.Background(), "q1", []int{1, 3}); err == nil || !strings.Contains(err.Error(), "out of range") {
+		t.Errorf("GetResultSets with index 3 error = %v, want out of range", err)
+	}
+}
+
+// bodyRecorder answers every request with response and records the decoded
+// JSON body of the last one.
+type bodyRecorder struct {
+	response string
+
+	mu   sync.Mutex
+	body map[string]interface{}
+}
+
+func (b *bodyRecorder) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var body map[string]interface{}
+	json.NewDecoder(r.Body).Decode(&body)
+	b.mu.Lock()
+	b.body = body
+	b.mu.Unlock()
+	w.Write([]byte(b.response))
+}
+
+func (b *bodyRecorder) lastBody() map[string]interface{} {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.body
+}
+
+func TestRequestExtraFields(t *testing.T) {
+	rec := &bodyRecorder{response: `{"id":"q1"}`}
+	c := newTestClient(t, rec, ClientConfig{})
+	extra := map[string]interface{}{"automatic": true, "text": "from extra", "name": "from extra"}
+	want := map[string]interface{}{"automatic": true, "text": "select 1", "name": "from extra"}
+
+	if _, err := c.CreateQueryFromRequest(context.Background(), CreateQueryRequest{Text: "select 1", Extra: extra}, "", ""); err != nil {
+		t.Fatalf("CreateQueryFromRequest: %v", err)
+	}
+	if got := rec.lastBody(); !reflect.DeepEqual(got, want) {
+		t.Errorf("create body = %v, want %v", got, want)
+	}
+
+	if err := c.ModifyQuery(context.Background(), "q1", ModifyQueryOptions{Text: "select 1", Extra: extra}, "", ""); err != nil {
+		t.Fatalf("ModifyQuery: %v", err)
+	}
+	if got := rec.lastBody(); !reflect.DeepEqual(got, want) {
+		t.Errorf("modify body = %v, want %v", got, want)
+	}
+}
+
+// statusSequence serves the status of query q1 from statuses, one per poll,
+// repeating the last one.
+func statusSequence(statuses ...string) http.HandlerFunc {
+	var polls int32
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/fq/v1/queries/q1/status" {
+			http.NotFound(w, r)
+			return
+		}
+		i := int(atomic.AddInt32(&polls, 1)) - 1
+		if i >= len(statuses) {
+			i = len(statuses) - 1
+		}
+		w.Write([]byte(`{"status":"` + statuses[i] + `"}`))
+	}
+}
+
+func TestWaitForStatusReachesTarget(t *testing.T) {
+	c := newTestClient(t, statusSequence("PENDING", "PENDING", "RUNNING", "COMPLETED"), ClientConfig{PollInterval: fastPoll})
+	if err := c.WaitForStatus(context.Background(), "q1", StatusRunning, time.Minute); err != nil {
+		t.Errorf("WaitForStatus: %v", err)
+	}
+}
+
+func TestWaitForStatusUnexpectedTerminal(t *testing.T) {
+	c := newTestClient(t, statusSequence("PENDING", "FAILED"), ClientConfig{PollInterval: fastPoll})
+	err := c.WaitForStatus(context.Background(), "q1", StatusRunning, time.Minute)
+	var mismatch *StatusMismatchError
+	if !errors.As(err, &mismatch) || mismatch.Status != StatusFailed || mismatch.Target != StatusRunning {
+		t.Errorf("WaitForStatus error = %v, want a *StatusMismatchError for FAILED", err)
+	}
+}
+
+func TestWaitForStatusTimeout(t *testing.T) {
+	c := newTestClient(t, statusSequence("PENDING"), ClientConfig{PollInterval: fastPoll})
+	err := c.WaitForStatus(context.Background(), "q1", StatusRunning, 20*time.Millisecond)
+	var waitErr *WaitError
+	if !errors.As(err, &waitErr) || !errors.Is(err, ErrExecutionTimeout) || waitErr.LastStatus != StatusPending {
+		t.Errorf("WaitForStatus error = %v, want a *WaitError timing out after PENDING", err)
+	}
+}
+
+// queryFixture serves query q1 as the given JSON object and its result sets
+// from results, recording the path and query string of every request.
+type queryFixture struct {
+	query   string
+	results map[int]string
+
+	mu       sync.Mutex
+	requests []string
+}
+
+func (f *queryFixture) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	f.requests = append(f.requests, r.URL.Path+"?"+r.URL.RawQuery)
+	f.mu.Unlock()
+
+	if r.URL.Path == "/api/fq/v1/queries/q1" {
+		w.Write([]byte(f.query))
+		return
+	}
+	idx, err := strconv.Atoi(strings.TrimPrefix(r.URL.Path, "/api/fq/v1/queries/q1/results/"))
+	if result, ok := f.results[idx]; err == nil && ok {
+		w.Write([]byte(result))
+		return
+	}
+	http.NotFound(w, r)
+}
+
+// resultRequests returns the recorded requests for result set rows.
+func (f *queryFixture) resultRequests() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var out []string
+	for _, req := range f.requests {
+		if strings.Contains(req, "/results/") {
+			out = append(out, req)
+		}
+	}
+	return out
+}
+
+func TestGetResultSetMeta(t *testing.T) {
+	fixture := &queryFixture{
+		query: `{"id":"q1","status":"COMPLETED","result_sets":[` +
+			`{"rows_count":1200,"truncated":true,"bytes":4096,"columns":[{"name":"n","type":"Int64"}]},` +
+			`{"rows_count":3}]}`,
+		results: map[int]string{1: `{"columns":[{"name":"s","type":"String"}],"rows":[["YQ=="]]}`},
+	}
+	c := newTestClient(t, fixture, ClientConfig{})
+
+	meta, err := c.GetResultSetMeta(context.Background(), "q1", 0)
+	if err != nil {
+		t.Fatalf("GetResultSetMeta(0): %v", err)
+	}
+	want := &ResultSetMeta{Index: 0, RowCount: 1200, Truncated: true, Bytes: 4096, Columns: []Column{{Name: "n", Type: "Int64"}}}
+	if !reflect.DeepEqual(meta, want) {
+		t.Errorf("GetResultSetMeta(0) = %+v, want %+v", meta, want)
+	}
+	if reqs := fixture.resultRequests(); len(reqs) != 0 {
+		t.Errorf("fetched rows %v although the metadata has the schema", reqs)
+	}
+
+	meta, err = c.GetResultSetMeta(context.Background(), "q1", 1)
+	if err != nil {
+		t.Fatalf("GetResultSetMeta(1): %v", err)
+	}
+	if meta.RowCount != 3 || !reflect.DeepEqual(meta.Columns, []Column{{Name: "s", Type: "String"}}) {
+		t.Errorf("GetResultSetMeta(1) = %+v", meta)
+	}
+	if reqs := fixture.resultRequests(); len(reqs) != 1 || !strings.Contains(reqs[0], "limit=1") {
+		t.Errorf("schema fetch requests = %v, want a single one-row page", reqs)
+	}
+
+	if _, err := c.GetResultSetMeta(context.Background(), "q1", 2); err == nil {
+		t.Error("GetResultSetMeta(2) succeeded for a missing result set")
+	}
+}
+
+func TestGetQueryResultSetUntilStopsAfterSecondPage(t *testing.T) {
+	var requests int32
+	c := newTestClient(t, rowsHandler(5000, &requests), ClientConfig{})
+
+	pages := 0
+	raw, err := c.GetQueryResultSetUntil(context.Background(), "q1", 0, true, func(page []interface{}) bool {
+		pages++
+		return pages == 2
+	})
+	if err != nil {
+		t.Fatalf("GetQueryResultSetUntil: %v", err)
+	}
+	if n := atomic.LoadInt32(&requests); n != 2 {
+		t.Errorf("fetched %d pages, want 2", n)
+	}
+	rows := raw["rows"].([]interface{})
+	if len(rows) != 2*resultSetPageSize || rows[len(rows)-1].([]interface{})[0] != float64(2*resultSetPageSize-1) {
+		t.Errorf("got %d rows, want the first %d", len(rows), 2*resultSetPageSize)
+	}
+}
+
+func TestGetResultSetCount(t *testing.T) {
+	tests := []struct {
+		query   string
+		want    int
+		wantErr error
+	}{
+		{query: `{"status":"COMPLETED","result_sets":[{},{}]}`, want: 2},
+		{query: `{"status":"COMPLETED"}`, want: 0},
+		{query: `{"status":"RUNNING"}`, wantErr: ErrQueryNotCompleted},
+		{query: `{"status":"FAILED","result_sets":[]}`, wantErr: ErrQueryNotCompleted},
+	}
+	for _, tt := range tests {
+		c := newTestClient(t, &queryFixture{query: tt.query}, ClientConfig{})
+		n, err := c.GetResultSetCount(context.Background(), "q1")
+		if n != tt.want || !errors.Is(err, tt.wantErr) {
+			t.Errorf("%s: GetResultSetCount = %d, %v; want %d, %v", tt.query, n, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestRunQueryIdempotencyKeys(t *testing.T) {
+	var mu sync.Mutex
+	keys := map[string][]string{}
+	c := newTestClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		keys[r.URL.Path] = append(keys[r.URL.Path], r.Header.Get("Idempotency-Key"))
+		mu.Unlock()
+		switch r.URL.Path {
+		case "/api/fq/v1/queries":
+			w.Write([]byte(`{"id":"q1"}`))
+		case "/api/fq/v1/queries/q1/status":
+			w.Write([]byte(`{"status":"RUNNING"}`))
+		case "/api/fq/v1/queries/q1/stop":
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			http.NotFound(w, r)
+		}
+	}), ClientConfig{PollInterval: fastPoll})
+
+	// Retrying the whole flow must reuse the same derived keys.
+	for attempt := 0; attempt < 2; attempt++ {
+		_, _, err := c.RunQuery(context.Background(), CreateQueryRequest{Text: "select 1"}, "nightly-1", 20*time.Millisecond, true)
+		if !errors.Is(err, ErrExecutionTimeout) {
+			t.Fatalf("attempt %d: RunQuery error = %v, want ErrExecutionTimeout", attempt, err)
+		}
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if got, want := keys["/api/fq/v1/queries"], []string{"nightly-1-create", "nightly-1-create"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("create keys = %q, want %q", got, want)
+	}
+	if got, want := keys["/api/fq/v1/queries/q1/stop"], []string{"nightly-1-stop", "nightly-1-stop"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("stop keys = %q, want %q", got, want)
+	}
+	for _, key := range keys["/api/fq/v1/queries/q1/status"] {
+		if key != "" {
+			t.Errorf("status poll sent Idempotency-Key %q", key)
+		}
+	}
+}
+
+func TestGetOpenAPISpecHeaders(t *testing.T) {
+	for _, auth := range []bool{false, true} {
+		var header http.Header
+		c := newTestClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			header = r.Header.Clone()
+			w.Write([]byte("openapi: 3.0.0\n"))
+		}), ClientConfig{UserAgent: "spec-test", OpenAPISpecAuth: auth})
+
+		spec, err := c.GetOpenAPISpec(context.Background())
+		if err != nil || spec != "openapi: 3.0.0\n" {
+			t.Fatalf("GetOpenAPISpec = %q, %v", spec, err)
+		}
+		if got := header.Get("User-Agent"); got != "spec-test" {
+			t.Errorf("User-Agent = %q, want spec-test", got)
+		}
+		if got, want := header.Get("Authorization"), map[bool]string{true: DefaultTokenPrefix + "test-token"}[auth]; got != want {
+			t.Errorf("OpenAPISpecAuth=%v: Authorization = %q, want %q", auth, got, want)
+		}
+	}
+}
+
+func TestGetOpenAPISpecCache(t *testing.T) {
+	var requests int32
+	release := make(chan struct{})
+	arrived := make(chan struct{}, 2)
+	c := newTestClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&requests, 1) == 1 {
+			arrived <- struct{}{}
+			<-release
+		}
+		w.Write([]byte("openapi: 3.0.0\n"))
+	}), ClientConfig{CacheOpenAPISpec: true})
+
+	slow := make(chan error, 1)
+	go func() {
+		_, err := c.GetOpenAPISpec(context.Background())
+		slow <- err
+	}()
+	<-arrived
+
+	// A slow first fetch must not hold up callers with their own deadlines.
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if _, err := c.GetOpenAPISpec(ctx); err != nil {
+		t.Errorf("GetOpenAPISpec during a slow fetch: %v", err)
+	}
+	close(release)
+	if err := <-slow; err != nil {
+		t.Fatalf("slow GetOpenAPISpec: %v", err)
+	}
+
+	before := atomic.LoadInt32(&requests)
+	if spec, err := c.GetOpenAPISpec(context.Background()); err != nil || spec != "openapi: 3.0.0\n" {
+		t.Errorf("cached GetOpenAPISpec = %q, %v", spec, err)
+	}
+	if n := atomic.LoadInt32(&requests); n != before {
+		t.Errorf("cached GetOpenAPISpec sent a request")
+	}
+}
+
+func TestStreamResultSetBatches(t *testing.T) {
+	c := newTestClient(t, rowsHandler(2500, nil), ClientConfig{})
+
+	var sizes []int
+	next := int64(0)
+	err := c.StreamResultSetBatches(context.Background(), "q1", 0, 700, func(batch []Row) error {
+		sizes = append(sizes, len(batch))
+		for _, row := range batch {
+			if row[0] != float64(next) {
+				return fmt.Errorf("row %d = %v", next, row[0])
+			}
+			next++
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("StreamResultSetBatches: %v", err)
+	}
+	if want := []int{700, 700, 700, 400}; !reflect.DeepEqual(sizes, want) {
+		t.Errorf("batch sizes = %v, want %v", sizes, want)
+	}
+
+	if err := c.StreamResultSetBatches(context.Background(), "q1", 0, 0, func([]Row) error { return nil }); err == nil {
+		t.Error("StreamResultSetBatches accepted a zero batch size")
+	}
+	stop := errors.New("stop")
+	if err := c.StreamResultSetBatches(context.Background(), "q1", 0, 100, func([]Row) error { return stop }); err != stop {
+		t.Errorf("StreamResultSetBatches error = %v, want the callback's error", err)
+	}
+}
+
+func TestWaitErrorCarriesLastStatus(t *testing.T) {
+	c := newTestClient(t, statusSequence("PENDING", "RUNNING"), ClientConfig{PollInterval: fastPoll})
+
+	_, err := c.WaitQueryToSucceed(context.Background(), "q1", 30*time.Millisecond, false)
+	var waitErr *WaitError
+	if !errors.As(err, &waitErr) || !errors.Is(err, ErrExecutionTimeout) {
+		t.Fatalf("WaitQueryToSucceed error = %v, want a *WaitError for the execution timeout", err)
+	}
+	if waitErr.QueryID != "q1" || waitErr.LastStatus != StatusRunning {
+		t.Errorf("WaitError = %+v, want query q1 last seen RUNNING", waitErr)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = c.WaitQueryToSucceed(ctx, "q1", 0, false)
+	if !errors.As(err, &waitErr) || !errors.Is(err, context.Canceled) {
+		t.Errorf("WaitQueryToSucceed error = %v, want a *WaitError for the cancellation", err)
+	}
+}
+
+func TestEstimateResultSetSize(t *testing.T) {
+	// The four sample rows encode as 61 bytes, so 1000 rows make 15250.
+	page := `{"columns":[{"name":"s","type":"Utf8"}],"rows":[` + strings.TrimSuffix(strings.Repeat(`["abcdefghij"],`, 4), ",") + `]}`
+	fixture := &queryFixture{
+		query:   `{"status":"COMPLETED","result_sets":[{"rows_count":10,"bytes":12345},{"rows_count":1000},{}]}`,
+		results: map[int]string{1: page},
+	}
+	c := newTestClient(t, fixture, ClientConfig{})
+	ctx := context.Background()
+
+	if rows, size, err := c.EstimateResultSetSize(ctx, "q1", 0); err != nil || rows != 10 || size != 12345 {
+		t.Errorf("reported size: EstimateResultSetSize = %d, %d, %v; want 10, 12345", rows, size, err)
+	}
+	if reqs := fixture.resultRequests(); len(reqs) != 0 {
+		t.Errorf("fetched rows %v although the size is reported", reqs)
+	}
+
+	if rows, size, err := c.EstimateResultSetSize(ctx, "q1", 1); err != nil || rows != 1000 || size != 15250 {
+		t.Errorf("sampled size: EstimateResultSetSize = %d, %d, %v; want 1000, 15250", rows, size, err)
+	}
+
+	if rows, size, err := c.EstimateResultSetSize(ctx, "q1", 2); err != nil || rows != -1 || size != 0 {
+		t.Errorf("unknown size: EstimateResultSetSize = %d, %d, %v; want -1, 0", rows, size, err)
+	}
+}
+
+type stubSigner struct {
+	signed int32
+}
+
+func (s *stubSigner) Sign(req *http.Request) error {
+	n := atomic.AddInt32(&s.signed, 1)
+	req.Header.Set("X-Signature", fmt.Sprintf("%s %s ts=%d", req.Method, req.URL.Path, n))
+	return nil
+}
+
+func TestSignerRunsOnEveryAttempt(t *testing.T) {
+	var mu sync.Mutex
+	var signatures []string
+	c := newTestClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		signatures = append(signatures, r.Header.Get("X-Signature"))
+		attempt := len(signatures)
+		mu.Unlock()
+		if attempt < 3 {
+			dropConnection(t, w)
+			return
+		}
+		w.Write([]byte(`{"status":"RUNNING"}`))
+	}), ClientConfig{Signer: &stubSigner{}}, WithRetryPolicy(RetryPolicy{MaxRetries: 2, BaseDelay: time.Millisecond}))
+
+	if _, err := c.GetQueryStatus(context.Background(), "q1", ""); err != nil {
+		t.Fatalf("GetQueryStatus: %v", err)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	want := []string{
+		"GET /api/fq/v1/queries/q1/status ts=1",
+		"GET /api/fq/v1/queries/q1/status ts=2",
+		"GET /api/fq/v1/queries/q1/status ts=3",
+	}
+	if !reflect.DeepEqual(signatures, want) {
+		t.Errorf("signatures = %q, want a fresh one per attempt %q", signatures, want)
+	}
+}
+
+// bodySigner signs the request body, read through GetBody as signers that
+// hash the payload do.
+type bodySigner struct{}
+
+func (bodySigner) Sign(req *http.Request) error {
+	body, err := req.GetBody()
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+	var buf bytes.Buffer
+	if _, err := buf.ReadFrom(body); err != nil {
+		return err
+	}
+	req.Header.Set("X-Signed-Body", buf.String())
+	return nil
+}
+
+func TestRetryResendsBody(t *testing.T) {
+	type attempt struct{ body, signed string }
+	var mu sync.Mutex
+	var attempts []attempt
+	c := newTestClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var buf bytes.Buffer
+		buf.ReadFrom(r.Body)
+		mu.Lock()
+		attempts = append(attempts, attempt{buf.String(), r.Header.Get("X-Signed-Body")})
+		n := len(attempts)
+		mu.Unlock()
+		if n < 3 {
+			dropConnection(t, w)
+			return
+		}
+		w.Write([]byte(`{"id":"q1"}`))
+	}), ClientConfig{Signer: bodySigner{}}, WithRetryPolicy(RetryPolicy{MaxRetries: 2, BaseDelay: time.Millisecond}))
+
+	if _, err := c.CreateQuery(context.Background(), "select 1", AnalyticsQueryType, "", "", "", ""); err != nil {
+		t.Fatalf("CreateQuery: %v", err)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(attempts) != 3 {
+		t.Fatalf("got %d attempts, want 3", len(attempts))
+	}
+	for i, a := range attempts {
+		if !strings.Contains(a.body, `"text":"select 1"`) || a.signed != a.body {
+			t.Errorf("attempt %d: body %q signed as %q, want the full body signed", i, a.body, a.signed)
+		}
+	}
+}
+
+func TestEndpointTrailingSlashes(t *testing.T) {
+	for _, suffix := range []string{"", "/", "//"} {
+		var path string
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			path = r.URL.Path
+			w.Write([]byte(`{"status":"RUNNING"}`))
+		}))
+		c := NewClient(ClientConfig{
+			Endpoint:   srv.URL + suffix,
+			WebBaseURL: "https://yq.example.com" + suffix,
+			Token:      "test-token",
+			Project:    "p1",
+		}, WithRetryPolicy(RetryPolicy{}))
+
+		if _, err := c.GetQueryStatus(context.Background(), "q1", ""); err != nil {
+			t.Errorf("endpoint %q: GetQueryStatus: %v", srv.URL+suffix, err)
+		}
+		if path != "/api/fq/v1/queries/q1/status" {
+			t.Errorf("endpoint %q: request path = %q", srv.URL+suffix, path)
+		}
+		if got := c.Endpoint(); got != srv.URL {
+			t.Errorf("Endpoint() = %q, want %q", got, srv.URL)
+		}
+		if got, want := c.ComposeQueryWebLink("q1"), "https://yq.example.com/folders/p1/ide/queries/q1"; got != want {
+			t.Errorf("web base %q: ComposeQueryWebLink = %q, want %q", suffix, got, want)
+		}
+		c.Close()
+		srv.Close()
+	}
+}
+
+func TestGetQueryIssues(t *testing.T) {
+	c := newTestClient(t, &queryFixture{query: `{"id":"q1","status":"ABORTED","issues":[{"message":"Query was aborted by user",` +
+		`"issue_code":1030,"severity":1,"position":{"row":2,"column":5},"issues":[{"message":"stop requested"}]}]}`}, ClientConfig{})
+
+	issues, err := c.GetQueryIssues(context.Background(), "q1")
+	if err != nil {
+		t.Fatalf("GetQueryIssues: %v", err)
+	}
+	want := []Issue{{
+		Message:   "Query was aborted by user",
+		IssueCode: 1030,
+		Severity:  1,
+		Row:       2,
+		Column:    5,
+		Issues:    []Issue{{Message: "stop requested", Issues: []Issue{}}},
+	}}
+	if !reflect.DeepEqual(issues, want) {
+		t.Errorf("GetQueryIssues = %+v, want %+v", issues, want)
+	}
+
+	for _, query := range []string{`{"status":"COMPLETED"}`, `{"status":"COMPLETED","issues":[]}`} {
+		c := newTestClient(t, &queryFixture{query: query}, ClientConfig{})
+		issues, err := c.GetQueryIssues(context.Background(), "q1")
+		if err != nil || issues == nil || len(issues) != 0 {
+			t.Errorf("%s: GetQueryIssues = %#v, %v; want an empty, non-nil slice", query, issues, err)
+		}
+	}
+}
+
+func TestQueryBuilder(t *testing.T) {
+	b := NewQueryBuilder().
+		Write("SELECT * FROM ").Identifier("logs`2024").
+		Write(" WHERE user = ").Param("user", "o'brien\n").
+		Write(" AND level >= ").Param("level", int32(3)).
+		Write(" AND ts > ").Param("since", time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)).
+		Write(" AND ratio < ").Param("ratio", 1.0).
+		Bind("unused", nil)
+
+	text, err := b.Build()
+	if err != nil {
+		t.Fatalf("Build: %v", err)
+	}
+	want := "$user = 'o\\'brien\\n'u;\n" +
+		"$level = 3;\n" +
+		"$since = Timestamp(\"2024-01-02T03:04:05.000000Z\");\n" +
+		"$ratio = 1.0;\n" +
+		"$unused = NULL;\n" +
+		"SELECT * FROM `logs\\`2024` WHERE user = $user AND level >= $level AND ts > $since AND ratio < $ratio"
+	if text != want {
+		t.Errorf("Build text:\n%s\nwant:\n%s", text, want)
+	}
+	wantParams := map[string]interface{}{
+		"user": "o'brien\n", "level": int32(3), "since": time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC), "ratio": 1.0, "unused": nil,
+	}
+	if params := b.Params(); !reflect.DeepEqual(params, wantParams) {
+		t.Errorf("Params = %v, want %v", params, wantParams)
+	}
+
+	if _, err := NewQueryBuilder().Param("bad name", 1).Build(); err == nil {
+		t.Error("Build accepted an invalid parameter name")
+	}
+	if _, err := NewQueryBuilder().Param("v", struct{}{}).Build(); err == nil {
+		t.Error("Build accepted an unsupported parameter type")
+	}
+}
+
+func TestAdaptivePollInterval(t *testing.T) {
+	tests := []struct {
+		elapsed time.Duration
+		want    time.Duration
+	}{
+		{0, 200 * time.Millisecond},
+		{9 * time.Second, 200 * time.Millisecond},
+		{10 * time.Second, time.Second},
+		{59 * time.Second, time.Second},
+		{time.Minute, 5 * time.Second},
+		{9 * time.Minute, 5 * time.Second},
+		{10 * time.Minute, 30 * time.Second},
+		{5 * time.Hour, 30 * time.Second},
+	}
+	for _, tt := range tests {
+		if got := AdaptivePollInterval(tt.elapsed); got != tt.want {
+			t.Errorf("AdaptivePollInterval(%v) = %v, want %v", tt.elapsed, got, tt.want)
+		}
+	}
+}
+
+func TestPollIntervalFuncIsUsed(t *testing.T) {
+	var mu sync.Mutex
+	var elapsed []time.Duration
+	c := newTestClient(t, statusSequence("RUNNING", "RUNNING", "RUNNING", "COMPLETED"), ClientConfig{
+		PollInterval: func(d time.Duration) time.Duration {
+			mu.Lock()
+			elapsed = append(elapsed, d)
+			mu.Unlock()
+			return time.Millisecond
+		},
+	})
+
+	if _, err := c.WaitQueryToComplete(context.Background(), "q1", 0, false); err != nil {
+		t.Fatalf("WaitQueryToComplete: %v", err)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(elapsed) != 4 || elapsed[0] != 0 {
+		t.Fatalf("PollInterval called with %v, want once up front and after each of 3 RUNNING polls", elapsed)
+	}
+	for i := 1; i < len(elapsed); i++ {
+		if elapsed[i] < elapsed[i-1] {
+			t.Errorf("elapsed went backwards: %v", elapsed)
+		}
+	}
+
+	c = newTestClient(t, statusSequence("RUNNING"), ClientConfig{})
+	if d := c.nextPollDelay(0, 0); d != 200*time.Millisecond {
+		t.Errorf("default first delay = %v, want 200ms", d)
+	}
+	if d := c.nextPollDelay(0, 1500*time.Millisecond); d != 2*time.Second {
+		t.Errorf("default delay after 1.5s = %v, want the 2s cap", d)
+	}
+}
+
+func TestConfigAccessors(t *testing.T) {
+	c := NewClient(ClientConfig{Token: "t", Project: "p1"})
+	defer c.Close()
+	if c.Endpoint() != DefaultEndpoint || c.WebBaseURL() != DefaultWebBaseURL || c.UserAgent() != DefaultUserAgent || c.Project() != "p1" {
+		t.Errorf("defaults: Endpoint=%q WebBaseURL=%q UserAgent=%q Project=%q", c.Endpoint(), c.WebBaseURL(), c.UserAgent(), c.Project())
+	}
+
+	c = NewClient(ClientConfig{Token: "t", Project: "p2", Endpoint: "https://yq.internal", WebBaseURL: "https://ui.internal", UserAgent: "tool/1.0"})
+	defer c.Close()
+	if c.Endpoint() != "https://yq.internal" || c.WebBaseURL() != "https://ui.internal" || c.UserAgent() != "tool/1.0" || c.Project() != "p2" {
+		t.Errorf("explicit: Endpoint=%q WebBaseURL=%q UserAgent=%q Project=%q", c.Endpoint(), c.WebBaseURL(), c.UserAgent(), c.Project())
+	}
+}
+
+func TestGetResultSetsUnioned(t *testing.T) {
+	fixture := &queryFixture{
+		results: map[int]string{
+			0: `{"columns":[{"name":"n","type":"Int64"}],"rows":[[1],[2]]}`,
+			1: `{"columns":[{"name":"n","type":"Int64"}],"rows":[[3]]}`,
+			2: `{"columns":[{"name":"n","type":"String"}],"rows":[["YQ=="]]}`,
+		},
+	}
+	c := newTestClient(t, fixture, ClientConfig{})
+
+	r, err := c.GetResultSetsUnioned(context.Background(), "q1", 2)
+	if err != nil {
+		t.Fatalf("GetResultSetsUnioned: %v", err)
+	}
+	want := [][]interface{}{{1.0}, {2.0}, {3.0}}
+	if got := r.ToTable(); !reflect.DeepEqual(got, want) {
+		t.Errorf("union rows = %v, want %v", got, want)
+	}
+	if got := r.Columns(); !reflect.DeepEqual(got, []Column{{Name: "n", Type: "Int64"}}) {
+		t.Errorf("union columns = %v", got)
+	}
+
+	if _, err := c.GetResultSetsUnioned(context.Background(), "q1", 3); err == nil || !strings.Contains(err.Error(), "result set 2") {
+		t.Errorf("union of mismatched schemas: err = %v, want an error naming result set 2", err)
+	}
+}
+
+func TestRetryPolicyDelay(t *testing.T) {
+	p := RetryPolicy{BaseDelay: 100 * time.Millisecond, MaxDelay: time.Second}
+	want := []time.Duration{100 * time.Millisecond, 200 * time.Millisecond, 400 * time.Millisecond, 800 * time.Millisecond, time.Second, time.Second}
+	for attempt, w := range want {
+		if got := p.Delay(attempt); got != w {
+			t.Errorf("Delay(%d) = %v, want %v", attempt, got, w)
+		}
+	}
+
+	p = RetryPolicy{BaseDelay: 100 * time.Millisecond, Multiplier: 3}
+	if got := p.Delay(2); got != 900*time.Millisecond {
+		t.Errorf("Delay(2) with multiplier 3 = %v, want 900ms", got)
+	}
+
+	p = RetryPolicy{BaseDelay: 100 * time.Millisecond, Jitter: 0.5}
+	for i := 0; i < 100; i++ {
+		if got := p.Delay(1); got < 100*time.Millisecond || got > 300*time.Millisecond {
+			t.Fatalf("Delay(1) with jitter 0.5 = %v, want within [100ms, 300ms]", got)
+		}
+	}
+}
+
+func TestClientContext(t *testing.T) {
+	if c, ok := FromContext(context.Background()); ok || c != nil {
+		t.Errorf("FromContext(empty) = %v, %v, want nil, false", c, ok)
+	}
+	if _, ok := FromContext(NewContext(context.Background(), nil)); ok {
+		t.Error("FromContext reports a nil client as present")
+	}
+
+	c := NewClient(ClientConfig{Token: "t", Project: "p"})
+	defer c.Close()
+	ctx := NewContext(context.Background(), c)
+	if got, ok := FromContext(ctx); !ok || got != c {
+		t.Errorf("FromContext = %p, %v, want %p, true", got, ok, c)
+	}
+	if got, ok := FromContext(WithCorrelationID(ctx, "r1")); !ok || got != c {
+		t.Errorf("FromContext(derived) = %p, %v, want %p, true", got, ok, c)
+	}
+}
+
+func TestGetAllResultSetSchemas(t *testing.T) {
+	fixture := &queryFixture{
+		query: `{"id":"q1","status":"COMPLETED","result_sets":[{"rows_count":2},{"rows_count":0}]}`,
+		results: map[int]string{
+			0: `{"columns":[{"name":"n","type":"Int64"},{"name":"s","type":"String"}],"rows":[[1,"YQ=="]]}`,
+			1: `{"columns":[{"name":"at","type":"Timestamp"}],"rows":[]}`,
+		},
+	}
+	c := newTestClient(t, fixture, ClientConfig{})
+
+	schemas, err := c.GetAllResultSetSchemas(context.Background(), "q1")
+	if err != nil {
+		t.Fatalf("GetAllResultSetSchemas: %v", err)
+	}
+	want := [][]Column{
+		{{Name: "n", Type: "Int64"}, {Name: "s", Type: "String"}},
+		{{Name: "at", Type: "Timestamp"}},
+	}
+	if !reflect.DeepEqual(schemas, want) {
+		t.Errorf("schemas = %v, want %v", schemas, want)
+	}
+
+	c = newTestClient(t, &queryFixture{query: `{"id":"q1","status":"COMPLETED"}`}, ClientConfig{})
+	schemas, err = c.GetAllResultSetSchemas(context.Background(), "q1")
+	if err != nil || schemas == nil || len(schemas) != 0 {
+		t.Errorf("GetAllResultSetSchemas without result sets = %v, %v, want an empty slice", schemas, err)
+	}
+}
+
+func TestListResultSets(t *testing.T) {
+	fixture := &queryFixture{
+		query: `{"id":"q1","status":"COMPLETED","result_sets":[` +
+			`{"rows_count":10,"truncated":true,"columns":[{"name":"n","type":"Int64"}]},` +
+			`{"rows_count":1,"bytes":12}]}`,
+		results: map[int]string{1: `{"columns":[{"name":"s","type":"String"}],"rows":[["YQ=="]]}`},
+	}
+	c := newTestClient(t, fixture, ClientConfig{})
+
+	metas, err := c.ListResultSets(context.Background(), "q1")
+	if err != nil {
+		t.Fatalf("ListResultSets: %v", err)
+	}
+	want := []ResultSetMeta{
+		{Index: 0, RowCount: 10, Truncated: true, Columns: []Column{{Name: "n", Type: "Int64"}}},
+		{Index: 1, RowCount: 1, Bytes: 12, Columns: []Column{{Name: "s", Type: "String"}}},
+	}
+	if !reflect.DeepEqual(metas, want) {
+		t.Errorf("ListResultSets = %+v, want %+v", metas, want)
+	}
+	if reqs := fixture.resultRequests(); len(reqs) != 1 || !strings.HasPrefix(reqs[0], "/api/fq/v1/queries/q1/results/1?") {
+		t.Errorf("schema fetch requests = %v, want one for result set 1 only", reqs)
+	}
+}
+
+func TestRetryQuery(t *testing.T) {
+	for _, description := range []string{"", "nightly report"} {
+		var mu sync.Mutex
+		var created map[string]interface{}
+		c := newTestClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method == http.MethodPost {
+				mu.Lock()
+				json.NewDecoder(r.Body).Decode(&created)
+				mu.Unlock()
+				w.Write([]byte(`{"id":"q2"}`))
+				return
+			}
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"id": "q1", "status": "FAILED", "text": "select 1", "type": "STREAMING", "name": "report", "description": description,
+			})
+		}), ClientConfig{})
+
+		id, err := c.RetryQuery(context.Background(), "q1")
+		if err != nil || id != "q2" {
+			t.Fatalf("RetryQuery = %q, %v, want q2", id, err)
+		}
+		note := "Retry of " + c.ComposeQueryWebLink("q1")
+		wantDescription := note
+		if description != "" {
+			wantDescription = description + "\n\n" + note
+		}
+		want := map[string]interface{}{"text": "select 1", "type": "STREAMING", "name": "report", "description": wantDescription}
+		mu.Lock()
+		if !reflect.DeepEqual(created, want) {
+			t.Errorf("retry body = %v, want %v", created, want)
+		}
+		mu.Unlock()
+	}
+}
+
+func TestWithAuthOverridesOneCall(t *testing.T) {
+	var mu sync.Mutex
+	var auths []string
+	c := newTestClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		auths = append(auths, r.Header.Get("Authorization"))
+		mu.Unlock()
+		w.Write([]byte(`{"status":"RUNNING"}`))
+	}), ClientConfig{})
+
+	ctx := context.Background()
+	for _, callCtx := range []context.Context{ctx, WithAuth(ctx, "Api-Key", "other-token"), ctx} {
+		if _, err := c.GetQueryStatus(callCtx, "q1", ""); err != nil {
+			t.Fatalf("GetQueryStatus: %v", err)
+		}
+	}
+
+	want := []string{DefaultTokenPrefix + "test-token", "Api-Key other-token", DefaultTokenPrefix + "test-token"}
+	mu.Lock()
+	defer mu.Unlock()
+	if !reflect.DeepEqual(auths, want) {
+		t.Errorf("Authorization headers = %q, want %q", auths, want)
+	}
+}
+
+func TestCheckProjectAccess(t *testing.T) {
+	var query string
+	var mu sync.Mutex
+	c := newTestClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		query = r.URL.RawQuery
+		mu.Unlock()
+		w.Write([]byte(`[]`))
+	}), ClientConfig{})
+	if err := c.CheckProjectAccess(context.Background()); err != nil {
+		t.Errorf("CheckProjectAccess: %v", err)
+	}
+	mu.Lock()
+	if !strings.Contains(query, "project=test-project") || !strings.Contains(query, "limit=1") {
+		t.Errorf("CheckProjectAccess query = %q, want the project and limit=1", query)
+	}
+	mu.Unlock()
+
+	c = newTestClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusForbidden)
+		w.Write([]byte(`{"status":"PERMISSION_DENIED","message":"no access"}`))
+	}), ClientConfig{})
+	var yqErr *YQError
+	if err := c.CheckProjectAccess(context.Background()); !errors.As(err, &yqErr) || yqErr.StatusCode != http.StatusForbidden {
+		t.Errorf("CheckProjectAccess without access = %v, want a 403 *YQError", err)
+	}
+}
+
+func TestContentNegotiationHeaders(t *testing.T) {
+	type seen struct{ method, accept, contentType string }
+	var mu sync.Mutex
+	var requests []seen
+	c := newTestClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		requests = append(requests, seen{r.Method, r.Header.Get("Accept"), r.Header.Get("Content-Type")})
+		mu.Unlock()
+		switch r.Method {
+		case http.MethodPost:
+			w.Write([]byte(`{"id":"q1"}`))
+		default:
+			w.Write([]byte(`{"status":"RUNNING"}`))
+		}
+	}), ClientConfig{})
+
+	ctx := context.Background()
+	if _, err := c.CreateQuery(ctx, "select 1", "", "", "", "", ""); err != nil {
+		t.Fatalf("CreateQuery: %v", err)
+	}
+	if _, err := c.GetQueryStatus(ctx, "q1", ""); err != nil {
+		t.Fatalf("GetQueryStatus: %v", err)
+	}
+
+	want := []seen{
+		{http.MethodPost, "application/json", "application/json"},
+		{http.MethodGet, "application/json", ""},
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if !reflect.DeepEqual(requests, want) {
+		t.Errorf("requests = %+v, want %+v", requests, want)
+	}
+}
+
+func TestResultSetCSVReader(t *testing.T) {
+	var requests int32
+	rows := 2*resultSetPageSize + 50
+	c := newTestClient(t, rowsHandler(rows, &requests), ClientConfig{})
+	rc := c.ResultSetCSVReader(context.Background(), "q1", 0)
+	records, err := csv.NewReader(rc).ReadAll()
+	rc.Close()
+	if err != nil {
+		t.Fatalf("reading CSV: %v", err)
+	}
+	if len(records) != rows+1 || records[0][0] != "n" || records[1][0] != "0" || records[rows][0] != strconv.Itoa(rows-1) {
+		t.Errorf("got %d records, first %v, last %v", len(records), records[0], records[len(records)-1])
+	}
+	if got := atomic.LoadInt32(&requests); got != 3 {
+		t.Errorf("fetched %d pages, want 3", got)
+	}
+
+	fixture := &queryFixture{results: map[int]string{0: `{"columns":[{"name":"s","type":"Utf8"},{"name":"n","type":"Int64"}],` +
+		`"rows":[["a, \"quoted\"\nline",1],[null,2]]}`}}
+	c = newTestClient(t, fixture, ClientConfig{})
+	rc = c.ResultSetCSVReader(context.Background(), "q1", 0)
+	defer rc.Close()
+	records, err = csv.NewReader(rc).ReadAll()
+	if err != nil {
+		t.Fatalf("reading CSV: %v", err)
+	}
+	want := [][]string{{"s", "n"}, {"a, \"quoted\"\nline", "1"}, {"", "2"}}
+	if !reflect.DeepEqual(records, want) {
+		t.Errorf("records = %q, want %q", records, want)
+	}
+}
+
+func TestRunQueryTemplate(t *testing.T) {
+	fake := &fakeYQ{finalStatus: "COMPLETED", resultSets: []string{`{"columns":[],"rows":[]}`}, runningPolls: -1}
+	c := newTestClient(t, fake, ClientConfig{PollInterval: fastPoll})
+
+	vars := map[string]string{"table": "logs/2024", "column": "odd`name"}
+	id, count, err := c.RunQueryTemplate(context.Background(), "SELECT {{column}} FROM {{ table }} WHERE {{column}} > 0", vars, CreateQueryRequest{}, WaitPolicy{})
+	if err != nil || id != "q1" || count != 1 {
+		t.Fatalf("RunQueryTemplate = %q, %d, %v", id, count, err)
+	}
+	fake.mu.Lock()
+	text := fake.text
+	fake.mu.Unlock()
+	if want := "SELECT `odd\\`name` FROM `logs/2024` WHERE `odd\\`name` > 0"; text != want {
+		t.Errorf("query text = %q, want %q", text, want)
+	}
+
+	fake = &fakeYQ{finalStatus: "COMPLETED"}
+	c = newTestClient(t, fake, ClientConfig{PollInterval: fastPoll})
+	_, _, err = c.RunQueryTemplate(context.Background(), "SELECT * FROM {{table}} JOIN {{other}} USING ({{key}})", map[string]string{"table": "t"}, CreateQueryRequest{}, WaitPolicy{})
+	if err == nil || err.Error() != "missing template variables: key, other" {
+		t.Errorf("RunQueryTemplate error = %v, want the sorted missing variables", err)
+	}
+	fake.mu.Lock()
+	created := fake.created
+	fake.mu.Unlock()
+	if created {
+		t.Error("a query was created despite missing variables")
+	}
+}
+
+func TestConfigSummary(t *testing.T) {
+	c := NewClient(ClientConfig{Token: "secret-token", Project: "p1"})
+	defer c.Close()
+	want := ConfigSummary{
+		Endpoint: DefaultEndpoint, EndpointDefault: true,
+		WebBaseURL: DefaultWebBaseURL, WebBaseURLDefault: true,
+		UserAgent: DefaultUserAgent, UserAgentDefault: true,
+		TokenPrefix: DefaultTokenPrefix, TokenPrefixDefault: true,
+		Project: "p1", TokenSet: true,
+	}
+	if got := c.ConfigSummary(); got != want {
+		t.Errorf("defaults: ConfigSummary = %+v, want %+v", got, want)
+	}
+
+	c = NewClient(ClientConfig{
+		Endpoint: "https://yq.example.com", WebBaseURL: "https://ui.example.com",
+		UserAgent: "my-app", TokenPrefix: "Api-Key", Project: "p2",
+	})
+	defer c.Close()
+	want = ConfigSummary{
+		Endpoint: "https://yq.example.com", WebBaseURL: "https://ui.example.com",
+		UserAgent: "my-app", TokenPrefix: "Api-Key ", Project: "p2",
+	}
+	if got := c.ConfigSummary(); got != want {
+		t.Errorf("overrides: ConfigSummary = %+v, want %+v", got, want)
+	}
+	s := c.ConfigSummary().String()
+	if !strings.Contains(s, "endpoint=https://yq.example.com (explicit)") || !strings.Contains(s, "token=not set") {
+		t.Errorf("String() = %q", s)
+	}
+
+	c = NewClient(ClientConfig{Token: "secret-token"})
+	defer c.Close()
+	if s := c.ConfigSummary().String(); strings.Contains(s, "secret-token") || !strings.Contains(s, "token=set (redacted)") {
+		t.Errorf("String() = %q, want the token redacted", s)
+	}
+}
+
+func TestGetResultSetRange(t *testing.T) {
+	var mu sync.Mutex
+	var queries []string
+	c := newTestClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		queries = append(queries, "offset="+r.URL.Query().Get("offset")+" limit="+r.URL.Query().Get("limit"))
+		mu.Unlock()
+		offset, _ := strconv.Atoi(r.URL.Query().Get("offset"))
+		limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+		var rows []string
+		for i := offset; i < 2500 && len(rows) < limit; i++ {
+			rows = append(rows, `["`+base64.StdEncoding.EncodeToString([]byte("row "+strconv.Itoa(i)))+`"]`)
+		}
+		w.Write([]byte(`{"columns":[{"name":"s","type":"String"}],"rows":[` + strings.Join(rows, ",") + `]}`))
+	}), ClientConfig{})
+
+	r, err := c.GetResultSetRange(context.Background(), "q1", 0, 10, 3)
+	if err != nil {
+		t.Fatalf("GetResultSetRange: %v", err)
+	}
+	if got := r.ToTable(); !reflect.DeepEqual(got, [][]interface{}{{"row 10"}, {"row 11"}, {"row 12"}}) {
+		t.Errorf("rows = %v, want rows 10 to 12 decoded", got)
+	}
+
+	mu.Lock()
+	queries = nil
+	mu.Unlock()
+	r, err = c.GetResultSetRange(context.Background(), "q1", 0, 1000, 1600)
+	if err != nil {
+		t.Fatalf("GetResultSetRange: %v", err)
+	}
+	if n := len(r.ToTable()); n != 1500 || r.Cell(n-1, 0) != "row 2499" {
+		t.Errorf("got %d rows ending with %v, want 1500 up to the end of the set", n, r.Cell(n-1, 0))
+	}
+	mu.Lock()
+	if want := []string{"offset=1000 limit=1000", "offset=2000 limit=600"}; !reflect.DeepEqual(queries, want) {
+		t.Errorf("page requests = %q, want %q", queries, want)
+	}
+	mu.Unlock()
+
+	for _, bad := range [][2]int{{-1, 10}, {0, 0}, {0, -5}} {
+		if _, err := c.GetResultSetRange(context.Background(), "q1", 0, bad[0], bad[1]); err == nil {
+			t.Errorf("GetResultSetRange(offset=%d, limit=%d) succeeded, want an error", bad[0], bad[1])
+		}
+	}
+}
+
+func TestTokenPrefixNormalization(t *testing.T) {
+	tests := []struct {
+		prefix string
+		want   string
+	}{
+		{"", DefaultTokenPrefix + "test-token"},
+		{"Bearer", "Bearer test-token"},
+		{"Bearer ", "Bearer test-token"},
+		{"Bearer   ", "Bearer test-token"},
+		{"Bearer\t", "Bearer test-token"},
+		{"OAuth \t ", "OAuth test-token"},
+	}
+	for _, tt := range tests {
+		var mu sync.Mutex
+		var auth string
+		c := newTestClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			mu.Lock()
+			auth = r.Header.Get("Authorization")
+			mu.Unlock()
+			w.Write([]byte(`{"status":"RUNNING"}`))
+		}), ClientConfig{TokenPrefix: tt.prefix})
+		if _, err := c.GetQueryStatus(context.Background(), "q1", ""); err != nil {
+			t.Fatalf("GetQueryStatus: %v", err)
+		}
+		mu.Lock()
+		if auth != tt.want {
+			t.Errorf("TokenPrefix %q: Authorization = %q, want %q", tt.prefix, auth, tt.want)
+		}
+		mu.Unlock()
+	}
+}
+
+func TestIteratorOffsets(t *testing.T) {
+	rows := resultSetPageSize + 5
+	c := newTestClient(t, rowsHandler(rows, nil), ClientConfig{})
+
+	it := c.IterateResultSet(context.Background(), "q1", 0)
+	n := 0
+	for ; it.Next(); n++ {
+		wantPage := 0
+		if n >= resultSetPageSize {
+			wantPage = resultSetPageSize
+		}
+		if it.Offset() != n || it.PageOffset() != wantPage || it.Row()[0] != float64(n) {
+			t.Fatalf("row %d: Offset() = %d, PageOffset() = %d, value %v", n, it.Offset(), it.PageOffset(), it.Row()[0])
+		}
+	}
+	if err := it.Err(); err != nil || n != rows {
+		t.Fatalf("iterated %d rows, err %v; want %d", n, err, rows)
+	}
+
+	it = c.IterateResultSetFrom(context.Background(), "q1", 0, rows-2)
+	if !it.Next() || it.Offset() != rows-2 || it.PageOffset() != rows-2 || it.Row()[0] != float64(rows-2) {
+		t.Errorf("resumed iterator: Offset() = %d, PageOffset() = %d", it.Offset(), it.PageOffset())
+	}
+}
+
+func TestNewClientFromEnv(t *testing.T) {
+	for _, name := range []string{"YQ_TOKEN", "YQ_PROJECT", "YQ_ENDPOINT", "YQ_WEB_BASE_URL", "YQ_USER_AGENT", "YQ_TOKEN_PREFIX", "YQ_DEBUG"} {
+		t.Setenv(name, "")
+	}
+
+	_, err := NewClientFromEnv()
+	if err == nil || err.Error() != "missing required environment variables: YQ_TOKEN, YQ_PROJECT" {
+		t.Errorf("NewClientFromEnv with nothing set: err = %v", err)
+	}
+	t.Setenv("YQ_TOKEN", "env-token")
+	if _, err := NewClientFromEnv(); err == nil || !strings.HasSuffix(err.Error(), ": YQ_PROJECT") {
+		t.Errorf("NewClientFromEnv without YQ_PROJECT: err = %v", err)
+	}
+
+	t.Setenv("YQ_PROJECT", "env-project")
+	t.Setenv("YQ_ENDPOINT", "https://yq.example.com")
+	t.Setenv("YQ_TOKEN_PREFIX", "OAuth")
+	t.Setenv("YQ_DEBUG", "true")
+	c, err := NewClientFromEnv()
+	if err != nil {
+		t.Fatalf("NewClientFromEnv: %v", err)
+	}
+	defer c.Close()
+	want := ConfigSummary{
+		Endpoint: "https://yq.example.com", WebBaseURL: DefaultWebBaseURL, WebBaseURLDefault: true,
+		UserAgent: DefaultUserAgent, UserAgentDefault: true, TokenPrefix: "OAuth ",
+		Project: "env-project", TokenSet: true,
+	}
+	if got := c.ConfigSummary(); got != want {
+		t.Errorf("ConfigSummary = %+v, want %+v", got, want)
+	}
+	if c.config.Token != "env-token" || !c.config.Debug {
+		t.Errorf("token %q, debug %v; want env-token and debug on", c.config.Token, c.config.Debug)
+	}
+
+	t.Setenv("YQ_DEBUG", "sometimes")
+	if _, err := NewClientFromEnv(); err == nil || !strings.Contains(err.Error(), "YQ_DEBUG") {
+		t.Errorf("NewClientFromEnv with a malformed YQ_DEBUG: err = %v", err)
+	}
+}
+
+func TestGetQueryResultSetPageLimits(t *testing.T) {
+	var requests int32
+	var mu sync.Mutex
+	var query string
+	handler := rowsHandler(250, &requests)
+	c := newTestClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		query = r.URL.RawQuery
+		mu.Unlock()
+		handler(w, r)
+	}), ClientConfig{})
+
+	page, err := c.GetQueryResultSetPage(context.Background(), "q1", 0, 0, 0, true, "")
+	if err != nil {
+		t.Fatalf("GetQueryResultSetPage: %v", err)
+	}
+	if rows := page["rows"].([]interface{}); len(rows) != defaultPageLimit {
+		t.Errorf("zero limit returned %d rows, want the default page of %d", len(rows), defaultPageLimit)
+	}
+	mu.Lock()
+	if strings.Contains(query, "limit=") || strings.Contains(query, "offset=") {
+		t.Errorf("zero offset and limit sent as %q, want them omitted", query)
+	}
+	mu.Unlock()
+
+	for _, bad := range [][2]int{{-1, 10}, {0, -1}} {
+		if _, err := c.GetQueryResultSetPage(context.Background(), "q1", 0, bad[0], bad[1], true, ""); err == nil {
+			t.Errorf("GetQueryResultSetPage(offset=%d, limit=%d) succeeded, want an error", bad[0], bad[1])
+		}
+	}
+	if n := atomic.LoadInt32(&requests); n != 1 {
+		t.Errorf("sent %d requests, want invalid pages rejected before sending", n)
+	}
+}
+
+func TestDescribeAndSampleResultSet(t *testing.T) {
+	var requests int32
+	c := newTestClient(t, rowsHandler(50, &requests), ClientConfig{})
+
+	r, err := c.DescribeAndSampleResultSet(context.Background(), "q1", 0, 5)
+	if err != nil {
+		t.Fatalf("DescribeAndSampleResultSet: %v", err)
+	}
+	if got := r.Columns(); !reflect.DeepEqual(got, []Column{{Name: "n", Type: "Int64"}}) {
+		t.Errorf("columns = %v", got)
+	}
+	if got := r.ToTable(); !reflect.DeepEqual(got, [][]interface{}{{0.0}, {1.0}, {2.0}, {3.0}, {4.0}}) {
+		t.Errorf("sample = %v, want the first 5 rows", got)
+	}
+
+	r, err = c.DescribeAndSampleResultSet(context.Background(), "q1", 0, resultSetPageSize)
+	if err != nil || len(r.ToTable()) != 50 {
+		t.Errorf("sample larger than the set: err = %v, want all 50 rows", err)
+	}
+	if n := atomic.LoadInt32(&requests); n != 2 {
+		t.Errorf("sent %d requests, want one per sample", n)
+	}
+
+	for _, bad := range []int{0, -1, resultSetPageSize + 1} {
+		if _, err := c.DescribeAndSampleResultSet(context.Background(), "q1", 0, bad); err == nil {
+			t.Errorf("DescribeAndSampleResultSet(%d) succeeded, want an error", bad)
+		}
+	}
+}
+
+func TestListQueries(t *testing.T) {
+	var mu sync.Mutex
+	var queries []string
+	c := newTestClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		queries = append(queries, r.URL.RawQuery)
+		mu.Unlock()
+		if r.URL.Query().Get("page_token") == "" {
+			w.Write([]byte(`{"queries":[{"id":"a","name":"etl","type":"ANALYTICS","status":"COMPLETED",` +
+				`"meta":{"started_at":"2024-03-01T12:00:00Z"}}],"next_page_token":"p2"}`))
+			return
+		}
+		w.Write([]byte(`{"queries":[{"id":"b","name":"etl-2","type":"ANALYTICS","status":"RUNNING"}]}`))
+	}), ClientConfig{})
+
+	opts := ListQueriesOptions{Name: "etl", Type: AnalyticsQueryType, Limit: 1}
+	var got []QuerySummary
+	for {
+		page, err := c.ListQueries(context.Background(), opts)
+		if err != nil {
+			t.Fatalf("ListQueries: %v", err)
+		}
+		got = append(got, page.Queries...)
+		if page.NextPageToken == "" {
+			break
+		}
+		opts.PageToken = page.NextPageToken
+	}
+
+	if ids := queryIDs(got); !reflect.DeepEqual(ids, []string{"a", "b"}) {
+		t.Fatalf("listed %v, want [a b]", ids)
+	}
+	first := got[0]
+	if first.Name != "etl" || first.Type != "ANALYTICS" || first.Status != StatusCompleted ||
+		!first.StartedAt.Equal(time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)) {
+		t.Errorf("first query = %+v", first)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	for i, q := range queries {
+		values, _ := url.ParseQuery(q)
+		if values.Get("project") != "test-project" || values.Get("name") != "etl" ||
+			values.Get("type") != "ANALYTICS" || values.Get("limit") != "1" {
+			t.Errorf("request %d query = %q, want the project, name, type and limit", i, q)
+		}
+		if want := map[int]string{0: "", 1: "p2"}[i]; values.Get("page_token") != want {
+			t.Errorf("request %d page_token = %q, want %q", i, values.Get("page_token"), want)
+		}
+	}
+	if len(queries) != 2 {
+		t.Errorf("sent %d requests, want 2", len(queries))
+	}
+}
+
+// recordingLogger collects the lines given to a Logger.
+type recordingLogger struct {
+	mu    sync.Mutex
+	lines []string
+}
+
+func (l *recordingLogger) Printf(format string, args ...interface{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.lines = append(l.lines, fmt.Sprintf(format, args...))
+}
+
+func TestRequestMetadataIsLoggedButNotSent(t *testing.T) {
+	var mu sync.Mutex
+	var sent *http.Request
+	logger := &recordingLogger{}
+	c := newTestClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		sent = r
+		mu.Unlock()
+		w.Write([]byte(`{"status":"RUNNING"}`))
+	}), ClientConfig{}, WithLogger(logger))
+
+	ctx := WithRequestMetadata(context.Background(), map[string]string{"tenant": "acme-tenant"})
+	ctx = WithRequestMetadata(ctx, map[string]string{"user": "u-42"})
+	if _, err := c.GetQueryStatus(ctx, "q1", ""); err != nil {
+		t.Fatalf("GetQueryStatus: %v", err)
+	}
+
+	logger.mu.Lock()
+	lines := logger.lines
+	logger.mu.Unlock()
+	if len(lines) != 1 || !strings.HasSuffix(lines[0], " tenant=acme-tenant user=u-42") {
+		t.Errorf("logged %q, want one line ending with the metadata", lines)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	for name, values := range sent.Header {
+		for _, v := range values {
+			if strings.Contains(v, "acme-tenant") || strings.Contains(v, "u-42") {
+				t.Errorf("header %s = %q carries the metadata", name, v)
+			}
+		}
+	}
+	if strings.Contains(sent.URL.RawQuery, "acme-tenant") || strings.Contains(sent.URL.RawQuery, "u-42") {
+		t.Errorf("query string %q carries the metadata", sent.URL.RawQuery)
+	}
+}
+
+func TestDeleteQuery(t *testing.T) {
+	type seen struct{ method, path, key, requestID string }
+	var mu sync.Mutex
+	var requests []seen
+	status := http.StatusNoContent
+	c := newTestClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		requests = append(requests, seen{r.Method, r.URL.Path, r.Header.Get("Idempotency-Key"), r.Header.Get("x-request-id")})
+		code := status
+		mu.Unlock()
+		w.WriteHeader(code)
+		if code == http.StatusNotFound {
+			w.Write([]byte(`{"status":"NOT_FOUND","message":"query not found"}`))
+		}
+	}), ClientConfig{})
+
+	if err := c.DeleteQuery(context.Background(), "q1", "delete-q1", "req-1"); err != nil {
+		t.Fatalf("DeleteQuery: %v", err)
+	}
+	mu.Lock()
+	status = http.StatusOK
+	mu.Unlock()
+	if err := c.DeleteQuery(context.Background(), "q2", "", ""); err != nil {
+		t.Errorf("DeleteQuery answered with 200: %v", err)
+	}
+	mu.Lock()
+	status = http.StatusNotFound
+	mu.Unlock()
+	var yqErr *YQError
+	if err := c.DeleteQuery(context.Background(), "q3", "", ""); !errors.As(err, &yqErr) || yqErr.StatusCode != http.StatusNotFound {
+		t.Errorf("DeleteQuery of a missing query = %v, want a 404 *YQError", err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	want := []seen{
+		{http.MethodDelete, "/api/fq/v1/queries/q1", "delete-q1", "req-1"},
+		{http.MethodDelete, "/api/fq/v1/queries/q2", "", ""},
+		{http.MethodDelete, "/api/fq/v1/queries/q3", "", ""},
+	}
+	if !reflect.DeepEqual(requests, want) {
+		t.Errorf("requests = %+v, want %+v", requests, want)
+	}
+}
+
+func TestModifyQuery(t *testing.T) {
+	var mu sync.Mutex
+	var method, path, key string
+	var body map[string]interface{}
+	c := newTestClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		method, path, key = r.Method, r.URL.Path, r.Header.Get("Idempotency-Key")
+		body = nil
+		json.NewDecoder(r.Body).Decode(&body)
+		mu.Unlock()
+		w.WriteHeader(http.StatusNoContent)
+	}), ClientConfig{})
+
+	opts := ModifyQueryOptions{Text: "select 2", Description: "fixed"}
+	if err := c.ModifyQuery(context.Background(), "q1", opts, "modify-q1", ""); err != nil {
+		t.Fatalf("ModifyQuery: %v", err)
+	}
+	mu.Lock()
+	if method != http.MethodPatch || path != "/api/fq/v1/queries/q1" || key != "modify-q1" {
+		t.Errorf("sent %s %s with key %q, want PATCH /api/fq/v1/queries/q1 with modify-q1", method, path, key)
+	}
+	if want := map[string]interface{}{"text": "select 2", "description": "fixed"}; !reflect.DeepEqual(body, want) {
+		t.Errorf("body = %v, want only the set fields %v", body, want)
+	}
+	mu.Unlock()
+
+	if err := c.ModifyQuery(context.Background(), "q1", ModifyQueryOptions{Name: "renamed"}, "", ""); err != nil {
+		t.Fatalf("ModifyQuery: %v", err)
+	}
+	mu.Lock()
+	if want := map[string]interface{}{"name": "renamed"}; !reflect.DeepEqual(body, want) || key != "" {
+		t.Errorf("body = %v with key %q, want %v without a key", body, key, want)
+	}
+	mu.Unlock()
+}
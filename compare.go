@@ -0,0 +1,253 @@
+package yq
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"reflect"
+	"time"
+)
+
+// Equal reports whether r and other have the same schema and the same
+// converted rows. On mismatch it also returns a description of the first
+// difference, e.g. for test failure messages.
+func (r *Results) Equal(other *Results) (bool, string) {
+	columns, otherColumns := r.Columns(), other.Columns()
+	if !equalColumns(columns, otherColumns) {
+		return false, fmt.Sprintf("columns differ: %v != %v", columns, otherColumns)
+	}
+
+	rows, otherRows := r.ToTable(), other.ToTable()
+	if len(rows) != len(otherRows) {
+		return false, fmt.Sprintf("row count differs: %d != %d", len(rows), len(otherRows))
+	}
+
+	for i := range rows {
+		if len(rows[i]) != len(otherRows[i]) {
+			return false, fmt.Sprintf("row %d: cell count differs: %d != %d", i, len(rows[i]), len(otherRows[i]))
+		}
+		for j := range rows[i] {
+			if !valuesEqual(rows[i][j], otherRows[i][j]) {
+				return false, fmt.Sprintf("row %d, column %s: %v != %v", i, columns[j].Name, rows[i][j], otherRows[i][j])
+			}
+		}
+	}
+	return true, ""
+}
+
+// valuesEqual compares converted values, treating equal instants and equal
+// decimals as equal regardless of their representation.
+func valuesEqual(a, b interface{}) bool {
+	switch a := a.(type) {
+	case time.Time:
+		b, ok := b.(time.Time)
+		return ok && a.Equal(b)
+	case *big.Rat:
+		b, ok := b.(*big.Rat)
+		return ok && (a == nil) == (b == nil) && (a == nil || a.Cmp(b) == 0)
+	case []byte:
+		b, ok := b.([]byte)
+		return ok && bytes.Equal(a, b)
+	case []interface{}:
+		b, ok := b.([]interface{})
+		if !ok || len(a) != len(b) {
+			return false
+		}
+		for i := range a {
+			if !valuesEqual(a[i], b[i]) {
+				return false
+			}
+		}
+		return true
+	case map[string]interface{}:
+		b, ok := b.(map[string]interface{})
+		if !ok || len(a) != len(b) {
+			return false
+		}
+		for k, v := range a {
+			w, ok := b[k]
+			if !ok || !valuesEqual(v, w) {
+				return false
+			}
+		}
+		return true
+	default:
+		return reflect.DeepEqual(a, b)
+	}
+}
+
+// ChangeKind is the kind of a row-level change between two snapshots.
+type ChangeKind int
+
+const (
+	RowInserted ChangeKind = iota + 1
+	RowUpdated
+	RowDeleted
+)
+
+func (k ChangeKind) String() string {
+	switch k {
+	case RowInserted:
+		return "insert"
+	case RowUpdated:
+		return "update"
+	case RowDeleted:
+		return "delete"
+	default:
+		return fmt.Sprintf("ChangeKind(%d)", int(k))
+	}
+}
+
+// RowChange is a row-level change between two snapshots of a result set.
+type RowChange struct {
+	Kind ChangeKind
+	// Key holds the values of the key columns.
+	Key []interface{}
+	// Old is the previous row; nil for inserts.
+	Old Row
+	// New is the current row; nil for deletes.
+	New Row
+	// Changed names the columns whose values differ; set for updates only.
+	Changed []string
+}
+
+// DiffResults computes the rows inserted, updated and deleted between two
+// snapshots of the same query, matching rows by the values of keyColumns.
+// Both snapshots must have the same schema and unique keys. Inserts and
+// updates are reported in the order of next, followed by deletes in the order
+// of prev.
+func DiffResults(prev, next *Results, keyColumns []string) ([]RowChange, error) {
+	columns := prev.Columns()
+	if !equalColumns(columns, next.Columns()) {
+		return nil, fmt.Errorf("diff: schemas differ: %v != %v", columns, next.Columns())
+	}
+	if len(keyColumns) == 0 {
+		return nil, fmt.Errorf("diff: no key columns")
+	}
+
+	keyIndices := make([]int, len(keyColumns))
+	for i, name := range keyColumns {
+		keyIndices[i] = -1
+		for j, col := range columns {
+			if col.Name == name {
+				keyIndices[i] = j
+				break
+			}
+		}
+		if keyIndices[i] < 0 {
+			return nil, fmt.Errorf("diff: unknown key column %q", name)
+		}
+	}
+
+	prevRows, err := indexRowsByKey(prev.ToTable(), keyIndices)
+	if err != nil {
+		return nil, fmt.Errorf("diff: previous snapshot: %w", err)
+	}
+	if _, err := indexRowsByKey(next.ToTable(), keyIndices); err != nil {
+		return nil, fmt.Errorf("diff: next snapshot: %w", err)
+	}
+
+	var changes []RowChange
+	seen := make(map[string]bool, len(prevRows))
+	for _, row := range next.ToTable() {
+		key, keyValues, _ := rowKey(row, keyIndices)
+		old, ok := prevRows[key]
+		if !ok {
+			changes = append(changes, RowChange{Kind: RowInserted, Key: keyValues, New: row})
+			continue
+		}
+		seen[key] = true
+
+		var changed []string
+		for j := range row {
+			if j >= len(old) || !valuesEqual(old[j], row[j]) {
+				changed = append(changed, columns[j].Name)
+			}
+		}
+		if len(changed) > 0 {
+			changes = append(changes, RowChange{Kind: RowUpdated, Key: keyValues, Old: old, New: row, Changed: changed})
+		}
+	}
+
+	for _, row := range prev.ToTable() {
+		key, keyValues, _ := rowKey(row, keyIndices)
+		if !seen[key] {
+			changes = append(changes, RowChange{Kind: RowDeleted, Key: keyValues, Old: row})
+		}
+	}
+	return changes, nil
+}
+
+func indexRowsByKey(rows [][]interface{}, keyIndices []int) (map[string]Row, error) {
+	index := make(map[string]Row, len(rows))
+	for i, row := range rows {
+		key, _, err := rowKey(row, keyIndices)
+		if err != nil {
+			return nil, fmt.Errorf("row %d: %w", i, err)
+		}
+		if _, ok := index[key]; ok {
+			return nil, fmt.Errorf("row %d: duplicate key %s", i, key)
+		}
+		index[key] = row
+	}
+	return index, nil
+}
+
+// rowKey encodes the key values of a row as a comparable string.
+func rowKey(row []interface{}, keyIndices []int) (string, []interface{}, error) {
+	values := make([]interface{}, len(keyIndices))
+	encoded := make([]interface{}, len(keyIndices))
+	for i, idx := range keyIndices {
+		if idx < len(row) {
+			values[i] = row[idx]
+			encoded[i] = jsonValue(row[idx])
+		}
+	}
+	key, err := json.Marshal(encoded)
+	if err != nil {
+		return "", nil, err
+	}
+	return string(key), values, nil
+}
+
+// RowStream is a stream of rows, such as a *ResultSetIterator.
+type RowStream interface {
+	Next() bool
+	Row() Row
+	Err() error
+}
+
+// CompareResultStreams walks two row streams in lockstep and returns an error
+// describing the first divergence, or nil if they hold the same rows. Only
+// the current row of each stream is held in memory.
+func CompareResultStreams(a, b RowStream) error {
+	for i := 0; ; i++ {
+		aOK, bOK := a.Next(), b.Next()
+		if err := a.Err(); err != nil {
+			return fmt.Errorf("first stream: %w", err)
+		}
+		if err := b.Err(); err != nil {
+			return fmt.Errorf("second stream: %w", err)
+		}
+
+		switch {
+		case !aOK && !bOK:
+			return nil
+		case !aOK:
+			return fmt.Errorf("row %d: first stream ended, second has %v", i, b.Row())
+		case !bOK:
+			return fmt.Errorf("row %d: second stream ended, first has %v", i, a.Row())
+		}
+
+		aRow, bRow := a.Row(), b.Row()
+		if len(aRow) != len(bRow) {
+			return fmt.Errorf("row %d: cell count differs: %d != %d", i, len(aRow), len(bRow))
+		}
+		for j := range aRow {
+			if !valuesEqual(aRow[j], bRow[j]) {
+				return fmt.Errorf("row %d, column %d: %v != %v", i, j, aRow[j], bRow[j])
+			}
+		}
+	}
+}
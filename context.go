@@ -0,0 +1,120 @@
+package yq
+
+import (
+	"context"
+	"time"
+)
+
+type contextKey int
+
+const (
+	impersonationKey contextKey = iota
+	clientKey
+	authKey
+	correlationIDKey
+	retryPolicyKey
+	requestMetadataKey
+)
+
+type impersonation struct {
+	header string
+	value  string
+}
+
+// WithImpersonation returns a context that makes calls made with it send the
+// given impersonation header instead of the one configured on the client.
+func WithImpersonation(ctx context.Context, header, value string) context.Context {
+	return context.WithValue(ctx, impersonationKey, impersonation{header: header, value: value})
+}
+
+func impersonationFromContext(ctx context.Context) (impersonation, bool) {
+	imp, ok := ctx.Value(impersonationKey).(impersonation)
+	return imp, ok
+}
+
+type authOverride struct {
+	prefix string
+	token  string
+}
+
+// WithAuth returns a context that makes calls made with it authenticate with
+// the given token prefix and token instead of the ones configured on the client.
+func WithAuth(ctx context.Context, tokenPrefix, token string) context.Context {
+	return context.WithValue(ctx, authKey, authOverride{prefix: tokenPrefix, token: token})
+}
+
+func authFromContext(ctx context.Context) (authOverride, bool) {
+	auth, ok := ctx.Value(authKey).(authOverride)
+	return auth, ok
+}
+
+// WithCorrelationID returns a context that makes every call made with it send
+// id as its x-request-id, unless the call is given an explicit request ID.
+// Errors returned by the API carry the ID in YQError.RequestID.
+func WithCorrelationID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, correlationIDKey, id)
+}
+
+func correlationIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(correlationIDKey).(string)
+	return id
+}
+
+// WithRequestMetadata returns a context that attaches metadata, e.g. tenant
+// or user identifiers, to the calls made with it. The metadata shows up in
+// the debug output and the lines given to the Logger, but is never sent to
+// the server. It is merged with metadata already in ctx; the new values win
+// on duplicate keys.
+func WithRequestMetadata(ctx context.Context, metadata map[string]string) context.Context {
+	merged := make(map[string]string, len(metadata))
+	for k, v := range RequestMetadata(ctx) {
+		merged[k] = v
+	}
+	for k, v := range metadata {
+		merged[k] = v
+	}
+	return context.WithValue(ctx, requestMetadataKey, merged)
+}
+
+// RequestMetadata returns the metadata attached to ctx by WithRequestMetadata.
+// The returned map must not be modified.
+func RequestMetadata(ctx context.Context) map[string]string {
+	metadata, _ := ctx.Value(requestMetadataKey).(map[string]string)
+	return metadata
+}
+
+// withRetryPolicy returns a context that makes calls made with it use policy
+// instead of the retry policy configured on the client.
+func withRetryPolicy(ctx context.Context, policy RetryPolicy) context.Context {
+	return context.WithValue(ctx, retryPolicyKey, policy)
+}
+
+func retryPolicyFromContext(ctx context.Context) (RetryPolicy, bool) {
+	policy, ok := ctx.Value(retryPolicyKey).(RetryPolicy)
+	return policy, ok
+}
+
+// NewContext returns a context carrying c.
+func NewContext(ctx context.Context, c *Client) context.Context {
+	return context.WithValue(ctx, clientKey, c)
+}
+
+// FromContext returns the client stored in ctx by NewContext, if any.
+func FromContext(ctx context.Context) (*Client, bool) {
+	c, ok := ctx.Value(clientKey).(*Client)
+	return c, ok && c != nil
+}
+
+// detachedContext keeps the values of its parent but not its deadline or
+// cancellation.
+type detachedContext struct {
+	parent context.Context
+}
+
+func (detachedContext) Deadline() (time.Time, bool) { return time.Time{}, false }
+func (detachedContext) Done() <-chan struct{}       { return nil }
+func (detachedContext) Err() error                  { return nil }
+
+func (c detachedContext) Value(key interface{}) interface{} {
+	return c.parent.Value(key)
+}
@@ -0,0 +1,322 @@
+package yq
+
+import (
+	"bytes"
+	"compress/gzip"
+	"container/list"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/big"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// converter turns a raw API value into its Go representation. Values of an
+// unexpected Go type are passed through unchanged; values that have the
+// expected type but can't be parsed produce an error.
+type converter func(interface{}) (interface{}, error)
+
+// converterCacheSize bounds the number of schemas whose converters are cached.
+const converterCacheSize = 128
+
+// converterCache is an LRU cache of converter slices keyed by the column types
+// of a schema, shared by all Results.
+var converterCache = struct {
+	sync.Mutex
+	order   *list.List
+	entries map[string]*list.Element
+}{
+	order:   list.New(),
+	entries: make(map[string]*list.Element),
+}
+
+type converterCacheEntry struct {
+	key        string
+	converters []converter
+}
+
+// schemaConverters returns the converters for the given column types, reusing
+// those built for an identical schema before. The returned slice is shared and
+// must not be modified.
+func schemaConverters(columnTypes []string) []converter {
+	key := strings.Join(columnTypes, "\x00")
+
+	converterCache.Lock()
+	defer converterCache.Unlock()
+
+	if el, ok := converterCache.entries[key]; ok {
+		converterCache.order.MoveToFront(el)
+		return el.Value.(*converterCacheEntry).converters
+	}
+
+	converters := make([]converter, len(columnTypes))
+	for i, columnType := range columnTypes {
+		converters[i] = getConverter(columnType)
+	}
+
+	converterCache.entries[key] = converterCache.order.PushFront(&converterCacheEntry{key: key, converters: converters})
+	if converterCache.order.Len() > converterCacheSize {
+		oldest := converterCache.order.Back()
+		converterCache.order.Remove(oldest)
+		delete(converterCache.entries, oldest.Value.(*converterCacheEntry).key)
+	}
+	return converters
+}
+
+func getConverter(columnType string) converter {
+	switch columnType {
+	case "Int8", "Int16", "Int32", "Int64", "Uint8", "Uint16", "Uint32", "Uint64", "Bool", "Utf8", "Uuid", "Void", "Null":
+		return convertIdentity
+	case "EmptyList", "Tuple<>":
+		return convertToEmptyList
+	case "EmptyDict", "Struct<>":
+		return convertToEmptyMap
+	case "String":
+		return convertFromBase64
+	case "JsonDocument":
+		return convertFromJSONDocument
+	case "Float", "Double":
+		return convertFromFloat
+	case "Date", "Datetime", "Timestamp":
+		return convertFromDatetime
+	case "pgint2", "pgint4", "pgint8", "pgoid":
+		return convertFromPgInt
+	case "pgfloat4", "pgfloat8":
+		return convertFromFloat
+	case "pgnumeric":
+		return convertFromPgNumeric
+	case "pgbool":
+		return convertFromPgBool
+	case "pgdate", "pgtimestamp", "pgtimestamptz":
+		return convertFromPgDatetime
+	case "pgtext", "pgvarchar", "pgbpchar", "pgchar", "pgname":
+		return convertIdentity
+	// Implement other type conversions as needed
+	default:
+		name, args, ok := parseTypeArgs(columnType)
+		switch {
+		case ok && name == "Struct":
+			return structConverter(args)
+		case ok && name == "Tagged" && len(args) == 2:
+			return getConverter(args[0])
+		}
+		return convertIdentity
+	}
+}
+
+// structConverter converts a struct value, which the API encodes positionally,
+// into a map keyed by field name with each field converted by its own type.
+func structConverter(fields []string) converter {
+	names := make([]string, len(fields))
+	converters := make([]converter, len(fields))
+	for i, field := range fields {
+		name, fieldType := splitStructField(field)
+		names[i] = name
+		converters[i] = getConverter(fieldType)
+	}
+
+	return func(value interface{}) (interface{}, error) {
+		values, ok := value.([]interface{})
+		if !ok {
+			return value, nil
+		}
+		if len(values) != len(names) {
+			return value, fmt.Errorf("struct has %d fields, got %d values", len(names), len(values))
+		}
+
+		result := make(map[string]interface{}, len(names))
+		for i, v := range values {
+			converted, err := converters[i](v)
+			if err != nil {
+				return value, fmt.Errorf("field %s: %w", names[i], err)
+			}
+			result[names[i]] = converted
+		}
+		return result, nil
+	}
+}
+
+func convertIdentity(value interface{}) (interface{}, error) {
+	return value, nil
+}
+
+func convertToEmptyList(value interface{}) (interface{}, error) {
+	if value == nil {
+		return nil, nil
+	}
+	return []interface{}{}, nil
+}
+
+func convertToEmptyMap(value interface{}) (interface{}, error) {
+	if value == nil {
+		return nil, nil
+	}
+	return map[string]interface{}{}, nil
+}
+
+func convertFromBase64(value interface{}) (interface{}, error) {
+	str, ok := value.(string)
+	if !ok {
+		return value, nil
+	}
+	decoded, err := base64.StdEncoding.DecodeString(str)
+	if err != nil {
+		return value, err
+	}
+	return string(decoded), nil
+}
+
+// convertFromJSONDocument parses a JsonDocument value into Go structures. The
+// document may arrive base64-encoded or as plain JSON text.
+func convertFromJSONDocument(value interface{}) (interface{}, error) {
+	str, ok := value.(string)
+	if !ok {
+		return value, nil
+	}
+
+	data := []byte(str)
+	if decoded, err := base64.StdEncoding.DecodeString(str); err == nil && json.Valid(decoded) {
+		data = decoded
+	}
+
+	var doc interface{}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return value, fmt.Errorf("invalid JsonDocument: %w", err)
+	}
+	return doc, nil
+}
+
+func convertFromFloat(value interface{}) (interface{}, error) {
+	switch v := value.(type) {
+	case float64:
+		return v, nil
+	case string:
+		f, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			return value, err
+		}
+		return f, nil
+	default:
+		return value, nil
+	}
+}
+
+func convertFromDatetime(value interface{}) (interface{}, error) {
+	str, ok := value.(string)
+	if !ok {
+		return value, nil
+	}
+	t, err := time.Parse(time.RFC3339, str)
+	if err != nil {
+		return value, err
+	}
+	return t, nil
+}
+
+// pgDatetimeLayouts are the textual forms PostgreSQL uses for date and timestamp values.
+var pgDatetimeLayouts = []string{
+	"2006-01-02 15:04:05.999999999Z07:00",
+	"2006-01-02 15:04:05.999999999Z07",
+	"2006-01-02 15:04:05.999999999",
+	"2006-01-02",
+}
+
+func convertFromPgInt(value interface{}) (interface{}, error) {
+	str, ok := value.(string)
+	if !ok {
+		return value, nil
+	}
+	i, err := strconv.ParseInt(str, 10, 64)
+	if err != nil {
+		return value, err
+	}
+	return i, nil
+}
+
+func convertFromPgNumeric(value interface{}) (interface{}, error) {
+	str, ok := value.(string)
+	if !ok {
+		return value, nil
+	}
+	n, ok := new(big.Rat).SetString(str)
+	if !ok {
+		return value, fmt.Errorf("invalid numeric %q", str)
+	}
+	return n, nil
+}
+
+func convertFromPgBool(value interface{}) (interface{}, error) {
+	str, ok := value.(string)
+	if !ok {
+		return value, nil
+	}
+	switch str {
+	case "t", "true":
+		return true, nil
+	case "f", "false":
+		return false, nil
+	default:
+		return value, fmt.Errorf("invalid bool %q", str)
+	}
+}
+
+func convertFromPgDatetime(value interface{}) (interface{}, error) {
+	str, ok := value.(string)
+	if !ok {
+		return value, nil
+	}
+	for _, layout := range pgDatetimeLayouts {
+		if t, err := time.Parse(layout, str); err == nil {
+			return t, nil
+		}
+	}
+	return value, fmt.Errorf("invalid date or timestamp %q", str)
+}
+
+// gunzipConverter decompresses gzip data produced by next.
+func gunzipConverter(next converter) converter {
+	return func(value interface{}) (interface{}, error) {
+		converted, err := next(value)
+		if err != nil {
+			return converted, err
+		}
+
+		str, ok := converted.(string)
+		if !ok || len(str) < 2 || str[0] != 0x1f || str[1] != 0x8b {
+			return converted, nil
+		}
+
+		zr, err := gzip.NewReader(bytes.NewReader([]byte(str)))
+		if err != nil {
+			return value, err
+		}
+		defer zr.Close()
+
+		decompressed, err := io.ReadAll(zr)
+		if err != nil {
+			return value, err
+		}
+		return string(decompressed), nil
+	}
+}
+
+// TaggedValue is a converted value of a Tagged<T, 'tag'> column, produced when
+// WithTaggedValues is set.
+type TaggedValue struct {
+	Tag   string
+	Value interface{}
+}
+
+func taggedConverter(tag string, next converter) converter {
+	return func(value interface{}) (interface{}, error) {
+		converted, err := next(value)
+		if err != nil {
+			return converted, err
+		}
+		return TaggedValue{Tag: tag, Value: converted}, nil
+	}
+}
@@ -0,0 +1,109 @@
+package yq
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// debugLog serializes debug dumps so that those of concurrent requests don't
+// interleave.
+type debugLog struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func (l *debugLog) write(b []byte) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.w.Write(b)
+}
+
+// debugRequest dumps the request line, headers and body of req.
+func (c *Client) debugRequest(req *http.Request, body []byte) {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "> %s %s\n", req.Method, req.URL)
+	if metadata := RequestMetadata(req.Context()); len(metadata) > 0 {
+		fmt.Fprintf(&buf, "> (metadata %s)\n", formatMetadata(metadata))
+	}
+	writeDebugHeaders(&buf, "> ", req.Header)
+	if len(body) > 0 {
+		fmt.Fprintf(&buf, ">\n> %s\n", body)
+	}
+	c.debug.write(buf.Bytes())
+}
+
+// debugResponse dumps the status line and headers of resp, and arranges for
+// its body to be dumped as it is read by the caller.
+func (c *Client) debugResponse(req *http.Request, resp *http.Response) {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "< %s %s: %s\n", req.Method, req.URL.Path, resp.Status)
+	writeDebugHeaders(&buf, "< ", resp.Header)
+	c.debug.write(buf.Bytes())
+
+	resp.Body = &debugBody{ReadCloser: resp.Body, client: c, path: req.URL.Path}
+}
+
+func (c *Client) debugError(req *http.Request, err error) {
+	c.debug.write([]byte(fmt.Sprintf("! %s %s: %v\n", req.Method, req.URL.Path, err)))
+}
+
+// formatMetadata formats request metadata as space-separated key=value pairs
+// sorted by key.
+func formatMetadata(metadata map[string]string) string {
+	keys := make([]string, 0, len(metadata))
+	for k := range metadata {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	pairs := make([]string, len(keys))
+	for i, k := range keys {
+		pairs[i] = k + "=" + metadata[k]
+	}
+	return strings.Join(pairs, " ")
+}
+
+func writeDebugHeaders(buf *bytes.Buffer, prefix string, header http.Header) {
+	keys := make([]string, 0, len(header))
+	for k := range header {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		for _, v := range header[k] {
+			if http.CanonicalHeaderKey(k) == "Authorization" {
+				v = "REDACTED"
+			}
+			fmt.Fprintf(buf, "%s%s: %s\n", prefix, k, v)
+		}
+	}
+}
+
+// debugBody tees a response body and dumps what was read on Close, leaving
+// the body intact for the caller.
+type debugBody struct {
+	io.ReadCloser
+	client *Client
+	path   string
+	buf    bytes.Buffer
+	closed bool
+}
+
+func (b *debugBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.buf.Write(p[:n])
+	return n, err
+}
+
+func (b *debugBody) Close() error {
+	if !b.closed {
+		b.closed = true
+		b.client.debug.write([]byte(fmt.Sprintf("< %s body: %s\n", b.path, bytes.TrimSpace(b.buf.Bytes()))))
+	}
+	return b.ReadCloser.Close()
+}
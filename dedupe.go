@@ -0,0 +1,92 @@
+package yq
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultDedupeTTL is how long a deduplicated create result is reused.
+	DefaultDedupeTTL = time.Minute
+	// DedupeCreateTimeout limits a create shared by coalesced callers. It runs
+	// independently of their contexts, so that one caller giving up doesn't
+	// fail the others.
+	DedupeCreateTimeout = time.Minute
+	// maxDedupeEntries bounds the number of idempotency keys remembered.
+	maxDedupeEntries = 1024
+)
+
+// createDedupe coalesces CreateQuery calls sharing an idempotency key into a
+// single request and remembers the outcome for a while.
+type createDedupe struct {
+	mu    sync.Mutex
+	calls map[string]*createCall
+}
+
+type createCall struct {
+	done    chan struct{}
+	id      string
+	err     error
+	expires time.Time
+}
+
+// do runs create once per key among concurrent and recent callers. The shared
+// create gets a context detached from the callers' cancellation, while each
+// caller stops waiting when its own ctx is done. Failed calls are forgotten so
+// that they can be retried.
+func (d *createDedupe) do(ctx context.Context, key string, ttl time.Duration, create func(ctx context.Context) (string, error)) (string, error) {
+	now := time.Now()
+
+	d.mu.Lock()
+	if d.calls == nil {
+		d.calls = make(map[string]*createCall)
+	}
+	if call, ok := d.calls[key]; ok && (call.expires.IsZero() || now.Before(call.expires)) {
+		d.mu.Unlock()
+		return call.wait(ctx)
+	}
+
+	if len(d.calls) >= maxDedupeEntries {
+		for k, call := range d.calls {
+			if !call.expires.IsZero() && now.After(call.expires) {
+				delete(d.calls, k)
+			}
+		}
+	}
+	if len(d.calls) >= maxDedupeEntries {
+		d.mu.Unlock()
+		return create(ctx)
+	}
+
+	call := &createCall{done: make(chan struct{})}
+	d.calls[key] = call
+	d.mu.Unlock()
+
+	go func() {
+		createCtx, cancel := context.WithTimeout(detachedContext{ctx}, DedupeCreateTimeout)
+		defer cancel()
+		id, err := create(createCtx)
+
+		d.mu.Lock()
+		call.id, call.err = id, err
+		if err != nil {
+			delete(d.calls, key)
+		} else {
+			call.expires = time.Now().Add(ttl)
+		}
+		d.mu.Unlock()
+		close(call.done)
+	}()
+
+	return call.wait(ctx)
+}
+
+func (call *createCall) wait(ctx context.Context) (string, error) {
+	select {
+	case <-call.done:
+		return call.id, call.err
+	case <-ctx.Done():
+		return "", ctx.Err()
+	}
+}
@@ -0,0 +1,79 @@
+package yq
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// includeDirective starts a line that is replaced by the contents of the file
+// it names, e.g. "-- include: common/filters.sql".
+const includeDirective = "-- include:"
+
+// CreateQueryFromFile creates a query whose text is read from the file at
+// path, with every "-- include: <file>" line replaced by the contents of that
+// file, resolved relative to the including file's directory. Includes may be
+// nested; include cycles are an error. The text of req is ignored.
+func (c *Client) CreateQueryFromFile(ctx context.Context, path string, req CreateQueryRequest) (string, error) {
+	text, err := readQueryFile(path, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Text = text
+	return c.CreateQueryFromRequest(ctx, req, "", "")
+}
+
+// readQueryFile reads a query file and resolves its includes. stack holds the
+// files currently being included, outermost first.
+func readQueryFile(path string, stack []string) (string, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return "", err
+	}
+	for i, p := range stack {
+		if p == abs {
+			cycle := append(append([]string(nil), stack[i:]...), abs)
+			return "", fmt.Errorf("include cycle: %s", strings.Join(cycle, " -> "))
+		}
+	}
+	stack = append(stack, abs)
+
+	data, err := os.ReadFile(abs)
+	if err != nil {
+		return "", err
+	}
+
+	var out strings.Builder
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(nil, len(data)+1)
+	for line := 1; scanner.Scan(); line++ {
+		text := scanner.Text()
+		trimmed := strings.TrimSpace(text)
+		if !strings.HasPrefix(trimmed, includeDirective) {
+			out.WriteString(text)
+			out.WriteByte('\n')
+			continue
+		}
+
+		name := strings.TrimSpace(strings.TrimPrefix(trimmed, includeDirective))
+		if name == "" {
+			return "", fmt.Errorf("%s:%d: empty include", abs, line)
+		}
+		if !filepath.IsAbs(name) {
+			name = filepath.Join(filepath.Dir(abs), name)
+		}
+		included, err := readQueryFile(name, stack)
+		if err != nil {
+			return "", fmt.Errorf("%s:%d: %w", abs, line, err)
+		}
+		out.WriteString(included)
+	}
+	if err := scanner.Err(); err != nil {
+		return "", err
+	}
+	return out.String(), nil
+}
@@ -0,0 +1,79 @@
+package yq
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// Issue is a problem reported by YQ for a query, such as a compilation error
+// or the reason a query was aborted. Issues may be nested.
+type Issue struct {
+	Message   string
+	IssueCode int
+	Severity  int
+	Row       int
+	Column    int
+	Issues    []Issue
+}
+
+// IssuesError is returned when a query finishes with a status other than
+// COMPLETED. It carries the issues YQ reported for the query.
+type IssuesError struct {
+	QueryID string
+	Status  QueryStatus
+	Issues  []Issue
+	// RequestID is the correlation ID of the call that observed the failure,
+	// see WithCorrelationID, if any.
+	RequestID string
+}
+
+func (e *IssuesError) Error() string {
+	messages := make([]string, len(e.Issues))
+	for i, issue := range e.Issues {
+		messages[i] = issue.Message
+	}
+	if e.RequestID != "" {
+		return fmt.Sprintf("query %s failed with status %s, issues=[%s] (RequestID: %s)", e.QueryID, e.Status, strings.Join(messages, "; "), e.RequestID)
+	}
+	return fmt.Sprintf("query %s failed with status %s, issues=[%s]", e.QueryID, e.Status, strings.Join(messages, "; "))
+}
+
+func parseIssues(raw interface{}) []Issue {
+	list, _ := raw.([]interface{})
+	issues := make([]Issue, 0, len(list))
+	for _, item := range list {
+		m, ok := item.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		issue := Issue{Issues: parseIssues(m["issues"])}
+		issue.Message, _ = m["message"].(string)
+		if code, ok := m["issue_code"].(float64); ok {
+			issue.IssueCode = int(code)
+		}
+		if severity, ok := m["severity"].(float64); ok {
+			issue.Severity = int(severity)
+		}
+		if pos, ok := m["position"].(map[string]interface{}); ok {
+			if row, ok := pos["row"].(float64); ok {
+				issue.Row = int(row)
+			}
+			if column, ok := pos["column"].(float64); ok {
+				issue.Column = int(column)
+			}
+		}
+		issues = append(issues, issue)
+	}
+	return issues
+}
+
+// GetQueryIssues returns the issues of a query, e.g. the reason a query was
+// aborted. It returns an empty slice if the query has no issues.
+func (c *Client) GetQueryIssues(ctx context.Context, queryID string) ([]Issue, error) {
+	query, err := c.GetQuery(ctx, queryID, "")
+	if err != nil {
+		return nil, err
+	}
+	return parseIssues(query["issues"]), nil
+}
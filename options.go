@@ -0,0 +1,57 @@
+package yq
+
+import (
+	"context"
+	"net/http"
+	"time"
+)
+
+// Option customizes a Client beyond its ClientConfig. Options are applied
+// after the config defaults.
+type Option func(*Client)
+
+// WithHTTPClient makes the client send requests with hc, e.g. one with a
+// custom transport or proxy.
+func WithHTTPClient(hc *http.Client) Option {
+	return func(c *Client) {
+		c.client = hc
+	}
+}
+
+// WithRetryPolicy sets the retry policy, overriding ClientConfig.RetryPolicy.
+func WithRetryPolicy(policy RetryPolicy) Option {
+	return func(c *Client) {
+		c.config.RetryPolicy = &policy
+	}
+}
+
+// Logger receives a line for every request attempt the client makes.
+// *log.Logger satisfies it.
+type Logger interface {
+	Printf(format string, args ...interface{})
+}
+
+// WithLogger makes the client log every request attempt to logger, along with
+// the metadata attached to its context by WithRequestMetadata.
+func WithLogger(logger Logger) Option {
+	return func(c *Client) {
+		c.logger = logger
+	}
+}
+
+// logAttempt logs the outcome of a request attempt, if a logger is set.
+func (c *Client) logAttempt(ctx context.Context, req *http.Request, resp *http.Response, err error, elapsed time.Duration) {
+	if c.logger == nil {
+		return
+	}
+
+	var metadata string
+	if m := RequestMetadata(ctx); len(m) > 0 {
+		metadata = " " + formatMetadata(m)
+	}
+	if err != nil {
+		c.logger.Printf("yq: %s %s failed after %s: %v%s", req.Method, req.URL.Path, elapsed, err, metadata)
+		return
+	}
+	c.logger.Printf("yq: %s %s %d in %s%s", req.Method, req.URL.Path, resp.StatusCode, elapsed, metadata)
+}
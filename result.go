@@ -1,22 +1,168 @@
 package yq
 
 import (
-	"encoding/base64"
+	"encoding/json"
+	"errors"
 	"fmt"
-	"strconv"
+	"sync"
 	"time"
 )
 
+// Column describes a result set column.
+type Column struct {
+	Name string
+	Type string
+}
+
+func parseColumns(raw interface{}) []Column {
+	list, _ := raw.([]interface{})
+	columns := make([]Column, 0, len(list))
+	for _, item := range list {
+		col, _ := item.(map[string]interface{})
+		name, _ := col["name"].(string)
+		colType, _ := col["type"].(string)
+		columns = append(columns, Column{Name: name, Type: colType})
+	}
+	return columns
+}
+
+func equalColumns(a, b []Column) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
 type Results struct {
 	rawResults map[string]interface{}
 	results    map[string]interface{}
+
+	strict bool
+	err    error
+
+	lazy       bool
+	converters []converter
+	cells      map[[2]int]interface{}
+
+	gunzipColumns map[string]bool
+	workers       int
+	keepTags      bool
+	ragged        RaggedRowMode
+	hooks         map[string][]func(interface{}) interface{}
+	typeMapping   TypeMapping
+
+	approxBytes int64
+	truncated   bool
+}
+
+// ResultsOption configures how Results converts raw values.
+type ResultsOption func(*Results)
+
+// WithStrictConversion makes conversion failures, such as malformed base64 in
+// a String column, surface as a *ConversionError from Err. By default failing
+// values are silently kept in their raw form.
+func WithStrictConversion() ResultsOption {
+	return func(r *Results) {
+		r.strict = true
+	}
+}
+
+// WithLazyConversion makes Cell convert values one at a time on access instead
+// of converting the whole result set up front. Results and ToTable still
+// convert everything.
+func WithLazyConversion() ResultsOption {
+	return func(r *Results) {
+		r.lazy = true
+	}
+}
+
+// WithGunzipColumns makes the named columns transparently decompress values
+// that are gzip-compressed after the usual conversion, e.g. base64 decoding of
+// String columns. Values without the gzip magic bytes are left as they are.
+func WithGunzipColumns(columns ...string) ResultsOption {
+	return func(r *Results) {
+		if r.gunzipColumns == nil {
+			r.gunzipColumns = make(map[string]bool, len(columns))
+		}
+		for _, name := range columns {
+			r.gunzipColumns[name] = true
+		}
+	}
+}
+
+// WithConversionWorkers converts rows in parallel on the given number of
+// goroutines, preserving row order. It only pays off for large result sets;
+// conversion is serial by default.
+func WithConversionWorkers(workers int) ResultsOption {
+	return func(r *Results) {
+		r.workers = workers
+	}
+}
+
+// WithTaggedValues makes values of Tagged<T, 'tag'> columns convert to
+// TaggedValue, carrying the tag alongside the value converted as T. By default
+// the tag is dropped and only the converted value is kept.
+func WithTaggedValues() ResultsOption {
+	return func(r *Results) {
+		r.keepTags = true
+	}
+}
+
+// ErrRaggedRow is reported for rows whose cell count doesn't match the columns
+// when RaggedRowsError is set.
+var ErrRaggedRow = errors.New("ragged row")
+
+// RaggedRowMode decides how rows with more or fewer cells than columns are
+// converted.
+type RaggedRowMode int
+
+const (
+	// RaggedRowsPad pads short rows with nil and drops extra cells.
+	RaggedRowsPad RaggedRowMode = iota
+	// RaggedRowsError converts like RaggedRowsPad but also reports
+	// ErrRaggedRow from Err.
+	RaggedRowsError
+)
+
+// WithRaggedRows sets how ragged rows are handled. The default is RaggedRowsPad.
+func WithRaggedRows(mode RaggedRowMode) ResultsOption {
+	return func(r *Results) {
+		r.ragged = mode
+	}
+}
+
+// ConversionError reports a value that could not be converted.
+type ConversionError struct {
+	Column string
+	Row    int
+	Err    error
+}
+
+func (e *ConversionError) Error() string {
+	if e.Column == "" {
+		return fmt.Sprintf("convert row %d: %v", e.Row, e.Err)
+	}
+	return fmt.Sprintf("convert row %d, column %q: %v", e.Row, e.Column, e.Err)
+}
+
+func (e *ConversionError) Unwrap() error {
+	return e.Err
 }
 
-func NewYQResults(results map[string]interface{}) *Results {
-	return &Results{
+func NewYQResults(results map[string]interface{}, opts ...ResultsOption) *Results {
+	r := &Results{
 		rawResults: results,
 		results:    nil,
 	}
+	for _, opt := range opts {
+		opt(r)
+	}
+	return r
 }
 
 func (r *Results) convert() {
@@ -27,19 +173,40 @@ func (r *Results) convert() {
 	columns := r.rawResults["columns"].([]interface{})
 	rows := r.rawResults["rows"].([]interface{})
 
-	converters := make([]func(interface{}) interface{}, len(columns))
-	for i, col := range columns {
-		colType := col.(map[string]interface{})["type"].(string)
-		converters[i] = r.getConverter(colType)
-	}
-
+	converters := r.columnConverters()
 	convertedRows := make([][]interface{}, len(rows))
-	for i, row := range rows {
-		convertedRow := make([]interface{}, len(converters))
-		for j, value := range row.([]interface{}) {
-			convertedRow[j] = converters[j](value)
+
+	workers := r.workers
+	if workers > len(rows) {
+		workers = len(rows)
+	}
+	if workers <= 1 {
+		if err := r.convertRows(rows, convertedRows, 0, len(converters)); err != nil && r.err == nil {
+			r.err = err
+		}
+	} else {
+		chunk := (len(rows) + workers - 1) / workers
+		errs := make([]error, workers)
+		var wg sync.WaitGroup
+		for w := 0; w < workers; w++ {
+			start := w * chunk
+			end := start + chunk
+			if end > len(rows) {
+				end = len(rows)
+			}
+			wg.Add(1)
+			go func(w, start, end int) {
+				defer wg.Done()
+				errs[w] = r.convertRows(rows[start:end], convertedRows[start:end], start, len(converters))
+			}(w, start, end)
+		}
+		wg.Wait()
+		for _, err := range errs {
+			if err != nil && r.err == nil {
+				r.err = err
+				break
+			}
 		}
-		convertedRows[i] = convertedRow
 	}
 
 	r.results = map[string]interface{}{
@@ -48,64 +215,308 @@ func (r *Results) convert() {
 	}
 }
 
-func (r *Results) getConverter(columnType string) func(interface{}) interface{} {
-	switch columnType {
-	case "Int8", "Int16", "Int32", "Int64", "Uint8", "Uint16", "Uint32", "Uint64", "Bool", "Utf8", "Uuid", "Void", "Null", "EmptyList", "Struct<>", "Tuple<>":
-		return func(v interface{}) interface{} { return v }
-	case "String":
-		return r.convertFromBase64
-	case "Float", "Double":
-		return r.convertFromFloat
-	case "Date", "Datetime", "Timestamp":
-		return r.convertFromDatetime
-	// Implement other type conversions as needed
-	default:
-		return func(v interface{}) interface{} { return v }
+// convertRows converts rows into out, numbering rows from first, and returns
+// the first strict-mode or ragged-row failure.
+func (r *Results) convertRows(rows []interface{}, out [][]interface{}, first, width int) error {
+	var firstErr error
+	for i, row := range rows {
+		values := row.([]interface{})
+		if len(values) != width {
+			if r.ragged == RaggedRowsError && firstErr == nil {
+				firstErr = &ConversionError{Row: first + i, Err: fmt.Errorf("%w: %d cells for %d columns", ErrRaggedRow, len(values), width)}
+			}
+			if len(values) > width {
+				values = values[:width]
+			}
+		}
+
+		convertedRow := make([]interface{}, width)
+		for j, value := range values {
+			converted, err := r.convertValue(first+i, j, value)
+			if err != nil && firstErr == nil {
+				firstErr = err
+			}
+			convertedRow[j] = converted
+		}
+		out[i] = convertedRow
 	}
+	return firstErr
 }
 
-func (r *Results) convertFromBase64(value interface{}) interface{} {
-	str, ok := value.(string)
-	if !ok {
-		return value
+func (r *Results) columnConverters() []converter {
+	if r.converters != nil {
+		return r.converters
+	}
+
+	columns := r.rawResults["columns"].([]interface{})
+	columnTypes := make([]string, len(columns))
+	for i, col := range columns {
+		columnTypes[i] = col.(map[string]interface{})["type"].(string)
 	}
-	decoded, err := base64.StdEncoding.DecodeString(str)
+
+	r.converters = make([]converter, len(columns))
+	copy(r.converters, schemaConverters(columnTypes))
+	for i, col := range columns {
+		name, _ := col.(map[string]interface{})["name"].(string)
+		if r.gunzipColumns[name] {
+			r.converters[i] = gunzipConverter(r.converters[i])
+		}
+		if t, ok := r.typeMapping[columnTypes[i]]; ok {
+			r.converters[i] = mappedConverter(r.converters[i], t)
+		}
+		if r.keepTags {
+			if typeName, args, ok := parseTypeArgs(columnTypes[i]); ok && typeName == "Tagged" && len(args) == 2 {
+				r.converters[i] = taggedConverter(unquote(args[1]), r.converters[i])
+			}
+		}
+	}
+	return r.converters
+}
+
+func (r *Results) convertCell(row, col int, value interface{}) interface{} {
+	converted, err := r.convertValue(row, col, value)
+	if err != nil && r.err == nil {
+		r.err = err
+	}
+	return converted
+}
+
+// convertValue converts a single value. On failure it returns the raw value,
+// along with a *ConversionError in strict mode.
+func (r *Results) convertValue(row, col int, value interface{}) (interface{}, error) {
+	converted, err := r.converters[col](value)
 	if err != nil {
-		return value
+		converted = value
+		if r.strict {
+			err = &ConversionError{Column: r.columnName(col), Row: row, Err: err}
+		} else {
+			err = nil
+		}
 	}
-	return string(decoded)
+
+	if len(r.hooks) > 0 {
+		for _, hook := range r.hooks[r.columnName(col)] {
+			converted = hook(converted)
+		}
+	}
+	return converted, err
 }
 
-func (r *Results) convertFromFloat(value interface{}) interface{} {
-	switch v := value.(type) {
-	case float64:
+func (r *Results) columnName(col int) string {
+	columns := r.rawResults["columns"].([]interface{})
+	name, _ := columns[col].(map[string]interface{})["name"].(string)
+	return name
+}
+
+// AddColumnHook registers fn to post-process every value of the named column
+// after type conversion, e.g. to redact or round it. Hooks also see values
+// kept raw because they failed to convert, and run in the order they were
+// added. Adding a hook discards previously converted values.
+func (r *Results) AddColumnHook(columnName string, fn func(interface{}) interface{}) {
+	if r.hooks == nil {
+		r.hooks = make(map[string][]func(interface{}) interface{})
+	}
+	r.hooks[columnName] = append(r.hooks[columnName], fn)
+
+	r.results = nil
+	r.converters = nil
+	r.cells = nil
+	r.err = nil
+}
+
+// Cell returns the converted value at the given row and column, or nil if
+// they are out of range. With WithLazyConversion only the requested cell is
+// converted and the result is cached; otherwise the whole set is converted on
+// first access.
+func (r *Results) Cell(row, col int) interface{} {
+	if !r.lazy || r.results != nil {
+		rows := r.ToTable()
+		if row < 0 || row >= len(rows) || col < 0 || col >= len(rows[row]) {
+			return nil
+		}
+		return rows[row][col]
+	}
+
+	key := [2]int{row, col}
+	if v, ok := r.cells[key]; ok {
 		return v
+	}
+
+	rows := r.rawResults["rows"].([]interface{})
+	if row < 0 || row >= len(rows) {
+		return nil
+	}
+	values := rows[row].([]interface{})
+	if col < 0 || col >= len(values) || col >= len(r.columnConverters()) {
+		return nil
+	}
+
+	if r.cells == nil {
+		r.cells = make(map[[2]int]interface{})
+	}
+	v := r.convertCell(row, col, values[col])
+	r.cells[key] = v
+	return v
+}
+
+func (r *Results) Results() map[string]interface{} {
+	r.convert()
+	return r.results
+}
+
+// Truncated reports whether the rows are only a prefix of the result set
+// because fetching was cut short, e.g. by MaxFetchDuration.
+func (r *Results) Truncated() bool {
+	return r.truncated
+}
+
+// ApproxBytes returns the approximate memory size of the raw rows as decoded
+// from the API, e.g. to decide whether to spill a result set to disk. Results
+// fetched by the client carry the size summed up while paging; otherwise it
+// is computed on first use. Projected results report the size of the rows
+// they were projected from, which stay referenced.
+func (r *Results) ApproxBytes() int64 {
+	if r.approxBytes == 0 {
+		r.approxBytes = approxSize(r.rawResults["rows"])
+	}
+	return r.approxBytes
+}
+
+// approxSize estimates the memory held by a decoded JSON value.
+func approxSize(value interface{}) int64 {
+	const headerSize = 16
+	switch v := value.(type) {
+	case nil:
+		return headerSize
 	case string:
-		f, err := strconv.ParseFloat(v, 64)
-		if err != nil {
-			return value
+		return headerSize + int64(len(v))
+	case []interface{}:
+		size := int64(24)
+		for _, item := range v {
+			size += approxSize(item)
+		}
+		return size
+	case map[string]interface{}:
+		size := int64(48)
+		for k, item := range v {
+			size += headerSize + int64(len(k)) + approxSize(item)
 		}
-		return f
+		return size
 	default:
-		return value
+		return headerSize + 8
 	}
 }
 
-func (r *Results) convertFromDatetime(value interface{}) interface{} {
-	str, ok := value.(string)
+// Err converts the results if needed and returns the first conversion failure.
+// It is always nil unless WithStrictConversion or RaggedRowsError is set.
+func (r *Results) Err() error {
+	r.convert()
+	return r.err
+}
+
+// ParseResults parses a result set payload in the API's {"columns", "rows"}
+// JSON shape, e.g. one fetched out of band, into Results ready for conversion.
+func ParseResults(rawJSON []byte, opts ...ResultsOption) (*Results, error) {
+	var payload map[string]interface{}
+	if err := json.Unmarshal(rawJSON, &payload); err != nil {
+		return nil, fmt.Errorf("parse results: %w", err)
+	}
+
+	columns, ok := payload["columns"].([]interface{})
 	if !ok {
-		return value
+		return nil, fmt.Errorf("parse results: missing or invalid columns")
 	}
-	t, err := time.Parse(time.RFC3339, str)
-	if err != nil {
-		return value
+	for i, col := range columns {
+		m, ok := col.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("parse results: column %d is not an object", i)
+		}
+		if _, ok := m["name"].(string); !ok {
+			return nil, fmt.Errorf("parse results: column %d has no name", i)
+		}
+		if _, ok := m["type"].(string); !ok {
+			return nil, fmt.Errorf("parse results: column %d has no type", i)
+		}
+	}
+
+	rows, ok := payload["rows"].([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("parse results: missing or invalid rows")
+	}
+	for i, row := range rows {
+		if _, ok := row.([]interface{}); !ok {
+			return nil, fmt.Errorf("parse results: row %d is not an array", i)
+		}
 	}
-	return t
+
+	return NewYQResults(map[string]interface{}{
+		"rows":    rows,
+		"columns": columns,
+	}, opts...), nil
 }
 
-func (r *Results) Results() map[string]interface{} {
-	r.convert()
-	return r.results
+// Columns returns the result set schema. Column types are the original YQL
+// type strings returned by the API; conversion never alters them.
+func (r *Results) Columns() []Column {
+	return parseColumns(r.rawResults["columns"])
+}
+
+// Project returns new Results holding only the named columns, in the given
+// order. It fails if a name is not present in the schema. The projection keeps
+// the conversion options and column hooks of r.
+func (r *Results) Project(columnNames []string) (*Results, error) {
+	columns := r.rawResults["columns"].([]interface{})
+	byName := make(map[string]int, len(columns))
+	for i := range columns {
+		byName[r.columnName(i)] = i
+	}
+
+	indices := make([]int, len(columnNames))
+	projectedColumns := make([]interface{}, len(columnNames))
+	for i, name := range columnNames {
+		idx, ok := byName[name]
+		if !ok {
+			return nil, fmt.Errorf("project: unknown column %q", name)
+		}
+		indices[i] = idx
+		projectedColumns[i] = columns[idx]
+	}
+
+	rows := r.rawResults["rows"].([]interface{})
+	projectedRows := make([]interface{}, len(rows))
+	for i, row := range rows {
+		values := row.([]interface{})
+		projected := make([]interface{}, len(indices))
+		for j, idx := range indices {
+			if idx < len(values) {
+				projected[j] = values[idx]
+			}
+		}
+		projectedRows[i] = projected
+	}
+
+	p := &Results{
+		rawResults: map[string]interface{}{
+			"rows":    projectedRows,
+			"columns": projectedColumns,
+		},
+		strict:        r.strict,
+		lazy:          r.lazy,
+		gunzipColumns: r.gunzipColumns,
+		workers:       r.workers,
+		keepTags:      r.keepTags,
+		ragged:        r.ragged,
+		typeMapping:   r.typeMapping,
+		approxBytes:   r.ApproxBytes(),
+		truncated:     r.truncated,
+	}
+	if len(r.hooks) > 0 {
+		p.hooks = make(map[string][]func(interface{}) interface{}, len(r.hooks))
+		for name, hooks := range r.hooks {
+			p.hooks[name] = append([]func(interface{}) interface{}(nil), hooks...)
+		}
+	}
+	return p, nil
 }
 
 func (r *Results) RawResults() map[string]interface{} {
@@ -117,6 +528,67 @@ func (r *Results) ToTable() [][]interface{} {
 	return r.results["rows"].([][]interface{})
 }
 
+// ValuePair holds a cell both as returned by the API and as converted.
+type ValuePair struct {
+	Raw       interface{}
+	Converted interface{}
+}
+
+// PairedRows returns the rows with every cell paired with its raw value, e.g.
+// to audit conversions. Cells missing from ragged raw rows have a nil Raw.
+func (r *Results) PairedRows() [][]ValuePair {
+	rawRows := r.rawResults["rows"].([]interface{})
+	rows := r.ToTable()
+
+	out := make([][]ValuePair, len(rows))
+	for i, row := range rows {
+		rawRow := rawRows[i].([]interface{})
+		pairs := make([]ValuePair, len(row))
+		for j, v := range row {
+			pairs[j].Converted = v
+			if j < len(rawRow) {
+				pairs[j].Raw = rawRow[j]
+			}
+		}
+		out[i] = pairs
+	}
+	return out
+}
+
+// RowsAsJSON returns each row as a standalone JSON object keyed by column name.
+// Times are encoded in RFC 3339 format and byte slices as strings.
+func (r *Results) RowsAsJSON() ([][]byte, error) {
+	columns := r.Columns()
+	rows := r.ToTable()
+
+	out := make([][]byte, len(rows))
+	for i, row := range rows {
+		obj := make(map[string]interface{}, len(columns))
+		for j, col := range columns {
+			if j < len(row) {
+				obj[col.Name] = jsonValue(row[j])
+			}
+		}
+		b, err := json.Marshal(obj)
+		if err != nil {
+			return nil, fmt.Errorf("row %d: %w", i, err)
+		}
+		out[i] = b
+	}
+	return out, nil
+}
+
+func jsonValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case time.Time:
+		return v.Format(time.RFC3339Nano)
+	case []byte:
+		return string(v)
+	default:
+		return v
+	}
+}
+
 func (r *Results) String() string {
 	r.convert()
 	return fmt.Sprintf("%v", r.results)
@@ -0,0 +1,850 @@
+package yq
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func mustParseResults(t testing.TB, rawJSON string, opts ...ResultsOption) *Results {
+	t.Helper()
+	r, err := ParseResults([]byte(rawJSON), opts...)
+	if err != nil {
+		t.Fatalf("ParseResults: %v", err)
+	}
+	return r
+}
+
+func TestStrictConversionErrorWithWorkers(t *testing.T) {
+	const raw = `{"columns":[{"name":"s","type":"String"}],"rows":[["!!not base64!!"]]}`
+	for _, workers := range []int{0, 1, 4} {
+		r := mustParseResults(t, raw, WithStrictConversion(), WithConversionWorkers(workers))
+		var convErr *ConversionError
+		if err := r.Err(); !errors.As(err, &convErr) {
+			t.Errorf("workers=%d: Err() = %v, want *ConversionError", workers, err)
+		}
+	}
+}
+
+func TestRaggedRowErrorWithWorkers(t *testing.T) {
+	const raw = `{"columns":[{"name":"a","type":"Int32"},{"name":"b","type":"Int32"}],"rows":[[1]]}`
+	for _, workers := range []int{0, 4} {
+		r := mustParseResults(t, raw, WithRaggedRows(RaggedRowsError), WithConversionWorkers(workers))
+		if err := r.Err(); !errors.Is(err, ErrRaggedRow) {
+			t.Errorf("workers=%d: Err() = %v, want ErrRaggedRow", workers, err)
+		}
+	}
+}
+
+func benchmarkRowsJSON(n int) string {
+	rows := make([]string, n)
+	for i := range rows {
+		rows[i] = fmt.Sprintf(`[%d,"aGVsbG8=","2024-01-02T03:04:05Z",%d.5]`, i, i)
+	}
+	return `{"columns":[{"name":"n","type":"Int64"},{"name":"s","type":"String"},` +
+		`{"name":"t","type":"Timestamp"},{"name":"f","type":"Double"}],"rows":[` + strings.Join(rows, ",") + `]}`
+}
+
+func benchmarkConvert(b *testing.B, workers int) {
+	raw := benchmarkRowsJSON(10000)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		b.StopTimer()
+		r := mustParseResults(b, raw, WithConversionWorkers(workers))
+		b.StartTimer()
+		r.ToTable()
+	}
+}
+
+func BenchmarkConvertSerial(b *testing.B)   { benchmarkConvert(b, 1) }
+func BenchmarkConvertParallel(b *testing.B) { benchmarkConvert(b, 4) }
+
+func TestConverterCacheKeepsPerResultsOptions(t *testing.T) {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	zw.Write([]byte("hello"))
+	zw.Close()
+	raw := `{"columns":[{"name":"s","type":"String"}],"rows":[["` + base64.StdEncoding.EncodeToString(buf.Bytes()) + `"]]}`
+
+	for i, gunzip := range []bool{true, false, true} {
+		var opts []ResultsOption
+		if gunzip {
+			opts = append(opts, WithGunzipColumns("s"))
+		}
+		got := mustParseResults(t, raw, opts...).Cell(0, 0)
+		if gunzip && got != "hello" {
+			t.Errorf("%d: gunzipped cell = %#v, want \"hello\"", i, got)
+		}
+		if !gunzip && got == "hello" {
+			t.Errorf("%d: cell gunzipped without WithGunzipColumns", i)
+		}
+	}
+}
+
+func TestConverterCacheEviction(t *testing.T) {
+	for i := 0; i < 2*converterCacheSize; i++ {
+		raw := fmt.Sprintf(`{"columns":[{"name":"v","type":"Struct<f%d:Double>"}],"rows":[[[%d.5]]]}`, i, i)
+		got := mustParseResults(t, raw).Cell(0, 0)
+		want := map[string]interface{}{fmt.Sprintf("f%d", i): float64(i) + 0.5}
+		if !reflect.DeepEqual(got, want) {
+			t.Fatalf("schema %d: cell = %#v, want %#v", i, got, want)
+		}
+	}
+
+	converterCache.Lock()
+	n, entries := converterCache.order.Len(), len(converterCache.entries)
+	converterCache.Unlock()
+	if n > converterCacheSize || entries != n {
+		t.Errorf("cache holds %d entries (%d indexed), want at most %d", n, entries, converterCacheSize)
+	}
+}
+
+func benchmarkColumnTypes() []string {
+	columnTypes := make([]string, 20)
+	for i := range columnTypes {
+		columnTypes[i] = fmt.Sprintf("Struct<id:Int64,name:String,at:Timestamp,score%d:Double>", i)
+	}
+	return columnTypes
+}
+
+func BenchmarkConvertersUncached(b *testing.B) {
+	columnTypes := benchmarkColumnTypes()
+	for i := 0; i < b.N; i++ {
+		converters := make([]converter, len(columnTypes))
+		for j, columnType := range columnTypes {
+			converters[j] = getConverter(columnType)
+		}
+	}
+}
+
+func BenchmarkConvertersCached(b *testing.B) {
+	columnTypes := benchmarkColumnTypes()
+	for i := 0; i < b.N; i++ {
+		schemaConverters(columnTypes)
+	}
+}
+
+type scanTestRow struct {
+	ID     int64     `yq:"id"`
+	Name   string    `yq:"name"`
+	Amount *big.Rat  `yq:"amount,scale=100"`
+	At     time.Time `yq:"at,unixseconds"`
+	Skip   string    `yq:"-"`
+}
+
+type scanTestName struct {
+	Name string
+}
+
+func TestScanConcurrent(t *testing.T) {
+	const raw = `{"columns":[{"name":"id","type":"Int64"},{"name":"name","type":"String"},` +
+		`{"name":"amount","type":"Int64"},{"name":"at","type":"Int64"}],` +
+		`"rows":[[1,"YQ==",1050,60],[2,"Yg==",-5,120]]}`
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 16)
+	for i := 0; i < 16; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			r, err := ParseResults([]byte(raw))
+			if err != nil {
+				errs <- err
+				return
+			}
+			if i%2 == 1 {
+				var names []scanTestName
+				if err := r.Scan(&names); err != nil {
+					errs <- err
+				} else if len(names) != 2 || names[1].Name != "b" {
+					errs <- fmt.Errorf("scanned %+v", names)
+				}
+				return
+			}
+			var rows []*scanTestRow
+			if err := r.Scan(&rows); err != nil {
+				errs <- err
+				return
+			}
+			if len(rows) != 2 || rows[0].ID != 1 || rows[0].Name != "a" ||
+				rows[0].Amount.Cmp(big.NewRat(21, 2)) != 0 || rows[1].Amount.Cmp(big.NewRat(-1, 20)) != 0 ||
+				!rows[1].At.Equal(time.Unix(120, 0)) {
+				errs <- fmt.Errorf("scanned %+v, %+v", rows[0], rows[1])
+			}
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+}
+
+func BenchmarkStructFieldsUncached(b *testing.B) {
+	structType := reflect.TypeOf(scanTestRow{})
+	for i := 0; i < b.N; i++ {
+		parseStructFields(structType)
+	}
+}
+
+func BenchmarkStructFieldsCached(b *testing.B) {
+	structType := reflect.TypeOf(scanTestRow{})
+	for i := 0; i < b.N; i++ {
+		cachedStructFields(structType)
+	}
+}
+
+func TestScaledDecimalHook(t *testing.T) {
+	r := mustParseResults(t, `{"columns":[{"name":"cents","type":"Int64"},{"name":"n","type":"Int64"}],`+
+		`"rows":[[1999,1999],["123456789012345678",1],[null,2]]}`)
+	hook, err := ScaledDecimalHook(100)
+	if err != nil {
+		t.Fatalf("ScaledDecimalHook(100): %v", err)
+	}
+	r.AddColumnHook("cents", hook)
+
+	want := []*big.Rat{big.NewRat(1999, 100), new(big.Rat)}
+	want[1].SetString("1234567890123456.78")
+	for i, w := range want {
+		got, ok := r.Cell(i, 0).(*big.Rat)
+		if !ok || got.Cmp(w) != 0 {
+			t.Errorf("row %d: cents = %v, want %s", i, r.Cell(i, 0), w.FloatString(2))
+		}
+	}
+	if got := r.Cell(2, 0); got != nil {
+		t.Errorf("null cents = %#v, want nil", got)
+	}
+	if got := r.Cell(0, 1); got != float64(1999) {
+		t.Errorf("unhooked column = %#v, want it unscaled", got)
+	}
+
+	for _, scale := range []int64{0, -100} {
+		if hook, err := ScaledDecimalHook(scale); err == nil || hook != nil {
+			t.Errorf("ScaledDecimalHook(%d) = %v, %v, want an error", scale, hook != nil, err)
+		}
+	}
+}
+
+func TestScanScaleTag(t *testing.T) {
+	r := mustParseResults(t, `{"columns":[{"name":"amount","type":"Int64"}],"rows":[[-250],["1000001"]]}`)
+
+	var rows []struct {
+		Amount *big.Rat `yq:"amount,scale=1000"`
+	}
+	if err := r.Scan(&rows); err != nil {
+		t.Fatalf("Scan: %v", err)
+	}
+	if rows[0].Amount.Cmp(big.NewRat(-1, 4)) != 0 || rows[1].Amount.Cmp(big.NewRat(1000001, 1000)) != 0 {
+		t.Errorf("scanned %s and %s", rows[0].Amount, rows[1].Amount)
+	}
+
+	var bad []struct {
+		Amount *big.Rat `yq:"amount,scale=0"`
+	}
+	if err := r.Scan(&bad); err == nil || !strings.Contains(err.Error(), "invalid scale") {
+		t.Errorf("Scan with scale=0 error = %v, want invalid scale", err)
+	}
+}
+
+func mustRat(s string) *big.Rat {
+	r, ok := new(big.Rat).SetString(s)
+	if !ok {
+		panic("invalid rational " + s)
+	}
+	return r
+}
+
+func TestPgTypes(t *testing.T) {
+	tests := []struct {
+		columnType string
+		raw        string
+		want       interface{}
+	}{
+		{"pgint4", `"-42"`, int64(-42)},
+		{"pgint8", `"9007199254740993"`, int64(9007199254740993)},
+		{"pgtext", `"hello, world"`, "hello, world"},
+		{"pgbool", `"t"`, true},
+		{"pgtimestamp", `"2024-01-02 03:04:05.5"`, time.Date(2024, 1, 2, 3, 4, 5, 5e8, time.UTC)},
+		{"pgtimestamptz", `"2024-01-02 03:04:05+03"`, time.Date(2024, 1, 2, 0, 4, 5, 0, time.UTC)},
+		{"pgdate", `"2024-01-02"`, time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC)},
+		{"pgnumeric", `"12345678901234567890.0001"`, mustRat("12345678901234567890.0001")},
+		{"pgnumeric", `"-0.5"`, big.NewRat(-1, 2)},
+		{"pgint4", `null`, nil},
+	}
+	for _, tt := range tests {
+		raw := `{"columns":[{"name":"v","type":"` + tt.columnType + `"}],"rows":[[` + tt.raw + `]]}`
+		r := mustParseResults(t, raw, WithStrictConversion())
+		got := r.Cell(0, 0)
+		if err := r.Err(); err != nil {
+			t.Errorf("%s %s: %v", tt.columnType, tt.raw, err)
+			continue
+		}
+
+		var ok bool
+		switch want := tt.want.(type) {
+		case time.Time:
+			g, isTime := got.(time.Time)
+			ok = isTime && g.Equal(want)
+		case *big.Rat:
+			g, isRat := got.(*big.Rat)
+			ok = isRat && g.Cmp(want) == 0
+		default:
+			ok = got == tt.want
+		}
+		if !ok {
+			t.Errorf("%s %s = %#v, want %#v", tt.columnType, tt.raw, got, tt.want)
+		}
+	}
+}
+
+func TestPgTypesMalformed(t *testing.T) {
+	for _, columnType := range []string{"pgint4", "pgnumeric", "pgbool", "pgtimestamp"} {
+		r := mustParseResults(t, `{"columns":[{"name":"v","type":"`+columnType+`"}],"rows":[["nope"]]}`, WithStrictConversion())
+		var convErr *ConversionError
+		if err := r.Err(); !errors.As(err, &convErr) {
+			t.Errorf("%s: Err() = %v, want *ConversionError", columnType, err)
+		}
+	}
+}
+
+func TestRowsAsJSON(t *testing.T) {
+	r := mustParseResults(t, `{"columns":[{"name":"n","type":"Int64"},{"name":"s","type":"String"},`+
+		`{"name":"t","type":"Timestamp"},{"name":"opt","type":"Optional<Int32>"}],`+
+		`"rows":[[1,"aGk=","2024-01-02T03:04:05Z",null],[2,"eyJhIjoxfQ==","2024-01-02T03:04:05.5Z",7]]}`)
+
+	rows, err := r.RowsAsJSON()
+	if err != nil {
+		t.Fatalf("RowsAsJSON: %v", err)
+	}
+	want := []map[string]interface{}{
+		{"n": 1.0, "s": "hi", "t": "2024-01-02T03:04:05Z", "opt": nil},
+		{"n": 2.0, "s": `{"a":1}`, "t": "2024-01-02T03:04:05.5Z", "opt": 7.0},
+	}
+	if len(rows) != len(want) {
+		t.Fatalf("got %d rows, want %d", len(rows), len(want))
+	}
+	for i, row := range rows {
+		var got map[string]interface{}
+		if err := json.Unmarshal(row, &got); err != nil {
+			t.Errorf("row %d is not valid JSON on its own: %v: %s", i, err, row)
+			continue
+		}
+		if !reflect.DeepEqual(got, want[i]) {
+			t.Errorf("row %d = %v, want %v", i, got, want[i])
+		}
+	}
+}
+
+func TestWriteTable(t *testing.T) {
+	r := mustParseResults(t, `{"columns":[{"name":"id","type":"Int64"},{"name":"name","type":"String"},`+
+		`{"name":"price","type":"Double"},{"name":"at","type":"Timestamp"}],`+
+		`"rows":[[1,"YXBwbGU=",1.5,"2024-01-02T03:04:05Z"],[1000,"cGluZWFwcGxlIGp1aWNl",12.25,null]]}`)
+
+	var out strings.Builder
+	if err := r.WriteTable(&out, 0); err != nil {
+		t.Fatalf("WriteTable: %v", err)
+	}
+	want := "" +
+		"  id  name             price  at\n" +
+		"   1  apple              1.5  2024-01-02T03:04:05Z\n" +
+		"1000  pineapple juice  12.25  \n"
+	if out.String() != want {
+		t.Errorf("WriteTable:\n%s\nwant:\n%s", out.String(), want)
+	}
+
+	out.Reset()
+	if err := r.WriteTable(&out, 6); err != nil {
+		t.Fatalf("WriteTable: %v", err)
+	}
+	want = "" +
+		"  id  name    price  at\n" +
+		"   1  apple     1.5  2024-…\n" +
+		"1000  pinea…  12.25  \n"
+	if out.String() != want {
+		t.Errorf("WriteTable capped at 6:\n%s\nwant:\n%s", out.String(), want)
+	}
+}
+
+func TestColumnsKeepOriginalTypes(t *testing.T) {
+	types := []string{"String", "Optional<Timestamp>", "Struct<a:Int32,b:String>", `Tagged<String,"url">`, "Decimal(22,9)", "pgint4"}
+	columns := make([]string, len(types))
+	cells := make([]string, len(types))
+	for i, columnType := range types {
+		encoded, _ := json.Marshal(columnType)
+		columns[i] = fmt.Sprintf(`{"name":"c%d","type":%s}`, i, encoded)
+		cells[i] = "null"
+	}
+	raw := `{"columns":[` + strings.Join(columns, ",") + `],"rows":[[` + strings.Join(cells, ",") + `]]}`
+
+	for _, opts := range [][]ResultsOption{nil, {WithLazyConversion()}, {WithTaggedValues()}} {
+		r := mustParseResults(t, raw, opts...)
+		r.Results()
+		r.ToTable()
+		got := r.Columns()
+		for i, columnType := range types {
+			if got[i].Name != fmt.Sprintf("c%d", i) || got[i].Type != columnType {
+				t.Errorf("Columns()[%d] = %+v after conversion, want type %s", i, got[i], columnType)
+			}
+		}
+	}
+}
+
+func TestLazyConversionMatchesEager(t *testing.T) {
+	raw := benchmarkRowsJSON(50)
+	eager := mustParseResults(t, raw)
+	lazy := mustParseResults(t, raw, WithLazyConversion())
+	for row := 0; row < 50; row += 7 {
+		for col := 0; col < 4; col++ {
+			if got, want := lazy.Cell(row, col), eager.Cell(row, col); !reflect.DeepEqual(got, want) {
+				t.Errorf("lazy Cell(%d, %d) = %#v, want %#v", row, col, got, want)
+			}
+		}
+	}
+	if lazy.results != nil {
+		t.Error("lazy Cell converted the whole result set")
+	}
+	if got := lazy.Cell(50, 0); got != nil {
+		t.Errorf("Cell out of range = %#v, want nil", got)
+	}
+}
+
+func benchmarkSparseCells(b *testing.B, opts ...ResultsOption) {
+	raw := mustParseResults(b, benchmarkRowsJSON(10000)).RawResults()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		r := NewYQResults(raw, opts...)
+		for row := 0; row < 10000; row += 1000 {
+			r.Cell(row, 1)
+		}
+	}
+}
+
+func BenchmarkSparseCellsEager(b *testing.B) { benchmarkSparseCells(b) }
+func BenchmarkSparseCellsLazy(b *testing.B)  { benchmarkSparseCells(b, WithLazyConversion()) }
+
+func TestEmptyContainerTypes(t *testing.T) {
+	tests := []struct {
+		columnType string
+		raw        string
+		want       interface{}
+	}{
+		{"EmptyList", `[]`, []interface{}{}},
+		{"EmptyList", `{}`, []interface{}{}},
+		{"Tuple<>", `[]`, []interface{}{}},
+		{"EmptyDict", `[]`, map[string]interface{}{}},
+		{"EmptyDict", `{}`, map[string]interface{}{}},
+		{"Struct<>", `[]`, map[string]interface{}{}},
+		{"EmptyList", `null`, nil},
+		{"EmptyDict", `null`, nil},
+	}
+	for _, tt := range tests {
+		r := mustParseResults(t, `{"columns":[{"name":"v","type":"`+tt.columnType+`"}],"rows":[[`+tt.raw+`]]}`)
+		if got := r.Cell(0, 0); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s %s = %#v, want %#v", tt.columnType, tt.raw, got, tt.want)
+		}
+	}
+}
+
+func TestStructConversion(t *testing.T) {
+	r := mustParseResults(t, `{"columns":[{"name":"v","type":"Struct<name:String,at:Timestamp,n:Int64>"}],`+
+		`"rows":[[["aGVsbG8=","2024-03-01T12:30:00Z",7]],[null]]}`)
+	want := map[string]interface{}{
+		"name": "hello",
+		"at":   time.Date(2024, 3, 1, 12, 30, 0, 0, time.UTC),
+		"n":    7.0,
+	}
+	if got := r.Cell(0, 0); !reflect.DeepEqual(got, want) {
+		t.Errorf("struct cell = %#v, want %#v", got, want)
+	}
+	if got := r.Cell(1, 0); got != nil {
+		t.Errorf("null struct cell = %#v, want nil", got)
+	}
+	if err := r.Err(); err != nil {
+		t.Errorf("Err() = %v", err)
+	}
+
+	r = mustParseResults(t, `{"columns":[{"name":"v","type":"Struct<name:String,at:Timestamp>"}],"rows":[[["aGVsbG8=","yesterday"]]]}`, WithStrictConversion())
+	if err := r.Err(); err == nil || !strings.Contains(err.Error(), "field at") {
+		t.Errorf("Err() = %v, want an error naming field at", err)
+	}
+}
+
+func TestGunzipColumns(t *testing.T) {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	zw.Write([]byte(`{"a":1}`))
+	zw.Close()
+	gzipped := base64.StdEncoding.EncodeToString(buf.Bytes())
+	plain := base64.StdEncoding.EncodeToString([]byte("plain"))
+	corrupt := base64.StdEncoding.EncodeToString([]byte{0x1f, 0x8b, 0x00})
+
+	raw := `{"columns":[{"name":"payload","type":"String"},{"name":"other","type":"String"}],` +
+		`"rows":[["` + gzipped + `","` + gzipped + `"],["` + plain + `",null],[null,null]]}`
+	r := mustParseResults(t, raw, WithGunzipColumns("payload"))
+	if got := r.Cell(0, 0); got != `{"a":1}` {
+		t.Errorf("gzipped cell = %#v, want the decompressed text", got)
+	}
+	if got := r.Cell(0, 1); got != buf.String() {
+		t.Errorf("cell outside the gunzip columns = %#v, want the compressed bytes", got)
+	}
+	if got := r.Cell(1, 0); got != "plain" {
+		t.Errorf("uncompressed cell = %#v, want \"plain\"", got)
+	}
+	if got := r.Cell(2, 0); got != nil {
+		t.Errorf("null cell = %#v, want nil", got)
+	}
+
+	r = mustParseResults(t, `{"columns":[{"name":"payload","type":"String"}],"rows":[["`+corrupt+`"]]}`,
+		WithGunzipColumns("payload"), WithStrictConversion())
+	var convErr *ConversionError
+	if err := r.Err(); !errors.As(err, &convErr) || convErr.Column != "payload" {
+		t.Errorf("Err() for corrupt gzip = %v, want a *ConversionError for payload", err)
+	}
+}
+
+func TestParseResultsRoundTrip(t *testing.T) {
+	raw := `{"columns":[{"name":"n","type":"Int64"},{"name":"s","type":"String"},{"name":"at","type":"Optional<Timestamp>"}],` +
+		`"rows":[[1,"YQ==","2024-03-01T12:30:00Z"],[2,"Yg==",null]]}`
+	first := mustParseResults(t, raw)
+	encoded, err := json.Marshal(first.RawResults())
+	if err != nil {
+		t.Fatal(err)
+	}
+	second := mustParseResults(t, string(encoded))
+	if !reflect.DeepEqual(first.ToTable(), second.ToTable()) || !reflect.DeepEqual(first.Columns(), second.Columns()) {
+		t.Errorf("round trip changed the results: %v %v -> %v %v", first.Columns(), first.ToTable(), second.Columns(), second.ToTable())
+	}
+}
+
+func TestParseResultsMalformed(t *testing.T) {
+	tests := []struct {
+		raw  string
+		want string
+	}{
+		{`not json`, "parse results"},
+		{`{"rows":[]}`, "missing or invalid columns"},
+		{`{"columns":{},"rows":[]}`, "missing or invalid columns"},
+		{`{"columns":["n"],"rows":[]}`, "column 0 is not an object"},
+		{`{"columns":[{"type":"Int64"}],"rows":[]}`, "column 0 has no name"},
+		{`{"columns":[{"name":"n"}],"rows":[]}`, "column 0 has no type"},
+		{`{"columns":[{"name":"n","type":"Int64"}]}`, "missing or invalid rows"},
+		{`{"columns":[{"name":"n","type":"Int64"}],"rows":[[1],2]}`, "row 1 is not an array"},
+	}
+	for _, tt := range tests {
+		r, err := ParseResults([]byte(tt.raw))
+		if err == nil || r != nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("ParseResults(%s) = %v, %v, want an error containing %q", tt.raw, r, err, tt.want)
+		}
+	}
+}
+
+func TestJSONSchema(t *testing.T) {
+	r := mustParseResults(t, `{"columns":[`+
+		`{"name":"ok","type":"Bool"},{"name":"n","type":"Uint64"},{"name":"price","type":"Decimal(22,9)"},`+
+		`{"name":"s","type":"Utf8"},{"name":"day","type":"Date"},{"name":"at","type":"Optional<Timestamp>"},`+
+		`{"name":"tags","type":"List<String>"},{"name":"point","type":"Struct<x:Double,y:Double>"},`+
+		`{"name":"other","type":"pgint4"}],"rows":[]}`)
+	got, err := r.JSONSchema()
+	if err != nil {
+		t.Fatalf("JSONSchema: %v", err)
+	}
+
+	want := `{
+		"$schema": "https://json-schema.org/draft/2020-12/schema",
+		"type": "object",
+		"properties": {
+			"ok": {"type": "boolean"},
+			"n": {"type": "integer"},
+			"price": {"type": "number"},
+			"s": {"type": "string"},
+			"day": {"type": "string", "format": "date"},
+			"at": {"type": ["string", "null"], "format": "date-time"},
+			"tags": {"type": "array", "items": {"type": "string"}},
+			"point": {"type": "object", "properties": {"x": {"type": "number"}, "y": {"type": "number"}}},
+			"other": {}
+		},
+		"required": ["ok", "n", "price", "s", "day", "tags", "point", "other"]
+	}`
+	var gotValue, wantValue interface{}
+	if err := json.Unmarshal(got, &gotValue); err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal([]byte(want), &wantValue); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(gotValue, wantValue) {
+		t.Errorf("JSONSchema = %s", got)
+	}
+}
+
+func TestScanTagOverrides(t *testing.T) {
+	r := mustParseResults(t, `{"columns":[{"name":"price","type":"Decimal(22,9)"},{"name":"ts","type":"Int64"}],`+
+		`"rows":[["12.345",1700000000123],["-0.000000001","1700000000000"],[null,null]]}`)
+
+	var rows []struct {
+		Price *big.Rat  `yq:"price,decimal"`
+		TS    time.Time `yq:"ts,unixmillis"`
+	}
+	if err := r.Scan(&rows); err != nil {
+		t.Fatalf("Scan: %v", err)
+	}
+	wantPrices := []*big.Rat{mustRat("12.345"), mustRat("-0.000000001"), nil}
+	wantTimes := []time.Time{time.UnixMilli(1700000000123).UTC(), time.UnixMilli(1700000000000).UTC(), {}}
+	for i := range rows {
+		if (rows[i].Price == nil) != (wantPrices[i] == nil) || (rows[i].Price != nil && rows[i].Price.Cmp(wantPrices[i]) != 0) {
+			t.Errorf("row %d: price = %v, want %v", i, rows[i].Price, wantPrices[i])
+		}
+		if !rows[i].TS.Equal(wantTimes[i]) {
+			t.Errorf("row %d: ts = %v, want %v", i, rows[i].TS, wantTimes[i])
+		}
+	}
+
+	var badValue []struct {
+		Price *big.Rat `yq:"price,decimal"`
+	}
+	r = mustParseResults(t, `{"columns":[{"name":"price","type":"Decimal(22,9)"}],"rows":[["twelve"]]}`)
+	if err := r.Scan(&badValue); err == nil || !strings.Contains(err.Error(), "invalid decimal") {
+		t.Errorf("Scan of a malformed decimal error = %v, want invalid decimal", err)
+	}
+
+	var badOption []struct {
+		TS time.Time `yq:"ts,unixnanos"`
+	}
+	if err := r.Scan(&badOption); err == nil || !strings.Contains(err.Error(), "unknown tag option") {
+		t.Errorf("Scan with an unknown option error = %v, want unknown tag option", err)
+	}
+}
+
+func TestTaggedColumns(t *testing.T) {
+	raw := `{"columns":[{"name":"link","type":"Tagged<String,'url'>"},{"name":"id","type":"Tagged<Int64, \"user_id\">"}],` +
+		`"rows":[["aHR0cHM6Ly95YS5ydQ==",42]]}`
+
+	r := mustParseResults(t, raw)
+	if got := r.ToTable()[0]; !reflect.DeepEqual(got, []interface{}{"https://ya.ru", 42.0}) {
+		t.Errorf("tagged row = %#v, want the base64-decoded url and the number", got)
+	}
+
+	r = mustParseResults(t, raw, WithTaggedValues())
+	want := []interface{}{TaggedValue{Tag: "url", Value: "https://ya.ru"}, TaggedValue{Tag: "user_id", Value: 42.0}}
+	if got := r.ToTable()[0]; !reflect.DeepEqual(got, want) {
+		t.Errorf("tagged row with WithTaggedValues = %#v, want %#v", got, want)
+	}
+}
+
+func TestAddColumnHookRedacts(t *testing.T) {
+	r := mustParseResults(t, `{"columns":[{"name":"email","type":"String"},{"name":"n","type":"Int64"}],`+
+		`"rows":[["YUBleGFtcGxlLmNvbQ==",1],[null,2]]}`)
+	if got := r.Cell(0, 0); got != "a@example.com" {
+		t.Fatalf("email before the hook = %#v", got)
+	}
+
+	var seen []interface{}
+	r.AddColumnHook("email", func(v interface{}) interface{} {
+		seen = append(seen, v)
+		if v == nil {
+			return nil
+		}
+		return "***"
+	})
+	r.AddColumnHook("email", func(v interface{}) interface{} {
+		if s, ok := v.(string); ok {
+			return s + "!"
+		}
+		return v
+	})
+
+	want := [][]interface{}{{"***!", 1.0}, {nil, 2.0}}
+	if got := r.ToTable(); !reflect.DeepEqual(got, want) {
+		t.Errorf("rows = %#v, want %#v", got, want)
+	}
+	if !reflect.DeepEqual(seen, []interface{}{"a@example.com", nil}) {
+		t.Errorf("hook saw %#v, want the converted values", seen)
+	}
+	if got := r.RawResults()["rows"].([]interface{})[0].([]interface{})[0]; got != "YUBleGFtcGxlLmNvbQ==" {
+		t.Errorf("raw email = %#v, want it unchanged", got)
+	}
+}
+
+func TestTypeMappingUint64AsString(t *testing.T) {
+	raw := `{"columns":[{"name":"u","type":"Uint64"},{"name":"i","type":"Int64"},{"name":"o","type":"Optional<Uint64>"}],` +
+		`"rows":[[42,42,7],[9223372036854775808,-1,null]]}`
+
+	r := mustParseResults(t, raw, WithTypeMapping(TypeMapping{"Uint64": GoString}))
+	want := [][]interface{}{{"42", 42.0, 7.0}, {"9223372036854775808", -1.0, nil}}
+	if got := r.ToTable(); !reflect.DeepEqual(got, want) {
+		t.Errorf("rows = %#v, want %#v", got, want)
+	}
+
+	r = mustParseResults(t, raw, WithTypeMapping(TypeMapping{"Uint64": GoUint64, "Int64": GoDefault}))
+	if got := r.Cell(1, 0); got != uint64(1<<63) {
+		t.Errorf("Uint64 as uint64 = %#v, want %d", got, uint64(1<<63))
+	}
+	if got := r.Cell(1, 1); got != -1.0 {
+		t.Errorf("Int64 mapped to GoDefault = %#v, want the default conversion", got)
+	}
+}
+
+func TestResultsEqual(t *testing.T) {
+	const columns = `"columns":[{"name":"n","type":"Int64"},{"name":"at","type":"Timestamp"}]`
+	a := mustParseResults(t, `{`+columns+`,"rows":[[1,"2024-03-01T12:00:00Z"],[2,null]]}`)
+	same := mustParseResults(t, `{`+columns+`,"rows":[[1,"2024-03-01T15:00:00+03:00"],[2,null]]}`)
+	if ok, diff := a.Equal(same); !ok {
+		t.Errorf("equal results reported different: %s", diff)
+	}
+
+	tests := []struct {
+		raw  string
+		want string
+	}{
+		{`{` + columns + `,"rows":[[1,"2024-03-01T12:00:00Z"],[3,null]]}`, "row 1, column n: 2 != 3"},
+		{`{` + columns + `,"rows":[[1,"2024-03-01T12:00:00Z"]]}`, "row count differs: 2 != 1"},
+		{`{"columns":[{"name":"n","type":"Int64"},{"name":"at","type":"Datetime"}],"rows":[[1,"2024-03-01T12:00:00Z"],[2,null]]}`, "columns differ"},
+	}
+	for _, tt := range tests {
+		ok, diff := a.Equal(mustParseResults(t, tt.raw))
+		if ok || !strings.HasPrefix(diff, tt.want) {
+			t.Errorf("Equal(%s) = %v, %q, want a difference starting with %q", tt.raw, ok, diff, tt.want)
+		}
+	}
+}
+
+func TestPairedRows(t *testing.T) {
+	r := mustParseResults(t, `{"columns":[{"name":"s","type":"String"},{"name":"at","type":"Timestamp"}],`+
+		`"rows":[["YQ==","2024-03-01T12:00:00Z"],["Yg=="]]}`)
+	want := [][]ValuePair{
+		{{Raw: "YQ==", Converted: "a"}, {Raw: "2024-03-01T12:00:00Z", Converted: time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)}},
+		{{Raw: "Yg==", Converted: "b"}, {}},
+	}
+	if got := r.PairedRows(); !reflect.DeepEqual(got, want) {
+		t.Errorf("PairedRows = %#v, want %#v", got, want)
+	}
+}
+
+func TestJSONDocumentColumn(t *testing.T) {
+	doc := `{"user":{"id":7,"tags":["a","b"]}}`
+	raw := `{"columns":[{"name":"d","type":"JsonDocument"},{"name":"j","type":"Json"}],"rows":[` +
+		`["` + base64.StdEncoding.EncodeToString([]byte(doc)) + `","{\"x\":1}"],` +
+		`["{\"plain\":true}",null],` +
+		`["not json",null]]}`
+	r := mustParseResults(t, raw)
+
+	want := map[string]interface{}{"user": map[string]interface{}{"id": 7.0, "tags": []interface{}{"a", "b"}}}
+	if got := r.Cell(0, 0); !reflect.DeepEqual(got, want) {
+		t.Errorf("base64 JsonDocument = %#v, want %#v", got, want)
+	}
+	if got := r.Cell(0, 1); got != `{"x":1}` {
+		t.Errorf("Json cell = %#v, want the text unchanged", got)
+	}
+	if got := r.Cell(1, 0); !reflect.DeepEqual(got, map[string]interface{}{"plain": true}) {
+		t.Errorf("plain JsonDocument = %#v", got)
+	}
+	if got := r.Cell(2, 0); got != "not json" {
+		t.Errorf("malformed JsonDocument = %#v, want the raw value", got)
+	}
+}
+
+func TestDiffResults(t *testing.T) {
+	const columns = `"columns":[{"name":"id","type":"Int64"},{"name":"name","type":"Utf8"},{"name":"score","type":"Double"}]`
+	prev := mustParseResults(t, `{`+columns+`,"rows":[[1,"ann",1.5],[2,"bob",2],[3,"cid",3]]}`)
+	next := mustParseResults(t, `{`+columns+`,"rows":[[3,"cid",3.5],[4,"dan",4],[1,"ann",1.5]]}`)
+
+	changes, err := DiffResults(prev, next, []string{"id"})
+	if err != nil {
+		t.Fatalf("DiffResults: %v", err)
+	}
+	want := []RowChange{
+		{Kind: RowUpdated, Key: []interface{}{3.0}, Old: Row{3.0, "cid", 3.0}, New: Row{3.0, "cid", 3.5}, Changed: []string{"score"}},
+		{Kind: RowInserted, Key: []interface{}{4.0}, New: Row{4.0, "dan", 4.0}},
+		{Kind: RowDeleted, Key: []interface{}{2.0}, Old: Row{2.0, "bob", 2.0}},
+	}
+	if !reflect.DeepEqual(changes, want) {
+		t.Errorf("DiffResults = %+v, want %+v", changes, want)
+	}
+
+	if changes, err := DiffResults(prev, prev, []string{"id", "name"}); err != nil || len(changes) != 0 {
+		t.Errorf("DiffResults of a snapshot with itself = %+v, %v, want no changes", changes, err)
+	}
+
+	duplicate := mustParseResults(t, `{`+columns+`,"rows":[[1,"ann",1],[1,"again",2]]}`)
+	other := mustParseResults(t, `{"columns":[{"name":"id","type":"Int64"}],"rows":[]}`)
+	for _, tt := range []struct {
+		prev, next *Results
+		keys       []string
+		want       string
+	}{
+		{prev, duplicate, []string{"id"}, "duplicate key"},
+		{prev, other, []string{"id"}, "schemas differ"},
+		{prev, next, nil, "no key columns"},
+		{prev, next, []string{"missing"}, `unknown key column "missing"`},
+	} {
+		if _, err := DiffResults(tt.prev, tt.next, tt.keys); err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("DiffResults error = %v, want one containing %q", err, tt.want)
+		}
+	}
+}
+
+// sliceStream is a RowStream over fixed rows that fails with err at the end.
+type sliceStream struct {
+	rows []Row
+	pos  int
+	err  error
+}
+
+func (s *sliceStream) Next() bool {
+	if s.pos >= len(s.rows) {
+		return false
+	}
+	s.pos++
+	return true
+}
+
+func (s *sliceStream) Row() Row { return s.rows[s.pos-1] }
+
+func (s *sliceStream) Err() error {
+	if s.pos >= len(s.rows) {
+		return s.err
+	}
+	return nil
+}
+
+func TestCompareResultStreams(t *testing.T) {
+	at := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	rows := func() []Row {
+		return []Row{{1.0, "a", at}, {2.0, "b", mustRat("1.50")}}
+	}
+	same := []Row{{1.0, "a", at.In(time.FixedZone("MSK", 3*3600))}, {2.0, "b", mustRat("3/2")}}
+	if err := CompareResultStreams(&sliceStream{rows: rows()}, &sliceStream{rows: same}); err != nil {
+		t.Errorf("identical streams: %v", err)
+	}
+
+	fetchErr := errors.New("connection reset")
+	tests := []struct {
+		name string
+		b    *sliceStream
+		want string
+	}{
+		{"different value", &sliceStream{rows: []Row{{1.0, "a", at}, {2.0, "c", mustRat("1.5")}}}, "row 1, column 1: b != c"},
+		{"shorter", &sliceStream{rows: rows()[:1]}, "row 1: second stream ended"},
+		{"longer", &sliceStream{rows: append(rows(), Row{3.0, "c", nil})}, "row 2: first stream ended"},
+		{"fewer cells", &sliceStream{rows: []Row{{1.0, "a"}}}, "row 0: cell count differs: 3 != 2"},
+		{"failing", &sliceStream{rows: rows()[:1], err: fetchErr}, "second stream: connection reset"},
+	}
+	for _, tt := range tests {
+		err := CompareResultStreams(&sliceStream{rows: rows()}, tt.b)
+		if err == nil || !strings.HasPrefix(err.Error(), tt.want) {
+			t.Errorf("%s: CompareResultStreams = %v, want an error starting with %q", tt.name, err, tt.want)
+		}
+	}
+	if err := CompareResultStreams(&sliceStream{rows: rows()[:1], err: fetchErr}, &sliceStream{rows: rows()}); !errors.Is(err, fetchErr) {
+		t.Errorf("failing first stream: CompareResultStreams = %v, want it to wrap the stream error", err)
+	}
+}
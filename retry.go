@@ -0,0 +1,50 @@
+package yq
+
+import (
+	"math"
+	"math/rand"
+	"time"
+)
+
+// RetryPolicy controls how requests failing at the transport level are retried.
+// The delay before retry n (starting at 0) is BaseDelay * Multiplier^n, capped
+// at MaxDelay and randomized by Jitter.
+type RetryPolicy struct {
+	// MaxRetries is the number of retries after the first attempt.
+	MaxRetries int
+	BaseDelay  time.Duration
+	// Multiplier is the growth factor between delays. Zero means 2.
+	Multiplier float64
+	// MaxDelay caps a single delay. Zero means no cap.
+	MaxDelay time.Duration
+	// Jitter randomizes each delay by up to this fraction of it, e.g. 0.2
+	// for ±20%. Zero disables jitter.
+	Jitter float64
+}
+
+// DefaultRetryPolicy returns the retry policy used when none is configured.
+func DefaultRetryPolicy() RetryPolicy {
+	return RetryPolicy{
+		MaxRetries: MaxRetryForSession,
+		BaseDelay:  TimeBetweenRetries,
+		Multiplier: 2,
+		MaxDelay:   30 * time.Second,
+	}
+}
+
+// Delay returns the delay before the given retry attempt, counting from 0.
+func (p RetryPolicy) Delay(attempt int) time.Duration {
+	multiplier := p.Multiplier
+	if multiplier == 0 {
+		multiplier = 2
+	}
+
+	delay := float64(p.BaseDelay) * math.Pow(multiplier, float64(attempt))
+	if p.MaxDelay > 0 && delay > float64(p.MaxDelay) {
+		delay = float64(p.MaxDelay)
+	}
+	if p.Jitter > 0 {
+		delay *= 1 + p.Jitter*(2*rand.Float64()-1)
+	}
+	return time.Duration(delay)
+}
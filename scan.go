@@ -0,0 +1,302 @@
+package yq
+
+import (
+	"fmt"
+	"math/big"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Scan decodes the converted rows into dest, which must be a pointer to a
+// slice of structs or struct pointers. Exported fields are matched to columns
+// by their `yq` tag name, or else by field name ignoring case. Fields tagged
+// `yq:"-"` are skipped.
+//
+// A tag option overrides the conversion of the raw column value:
+//
+//	yq:"amount,decimal"     parse as an exact decimal (*big.Rat)
+//	yq:"amount,scale=100"   parse a scaled integer, e.g. cents, as a decimal divided by 100
+//	yq:"ts,unixmillis"      interpret a number as Unix milliseconds
+//	yq:"ts,unixseconds"     interpret a number as Unix seconds
+func (r *Results) Scan(dest interface{}) error {
+	slice := reflect.ValueOf(dest)
+	if slice.Kind() != reflect.Ptr || slice.Elem().Kind() != reflect.Slice {
+		return fmt.Errorf("scan: dest must be a pointer to a slice, got %T", dest)
+	}
+	slice = slice.Elem()
+
+	elemType := slice.Type().Elem()
+	structType := elemType
+	if structType.Kind() == reflect.Ptr {
+		structType = structType.Elem()
+	}
+	if structType.Kind() != reflect.Struct {
+		return fmt.Errorf("scan: slice elements must be structs, got %s", elemType)
+	}
+
+	fields, err := scanFields(structType, r.Columns())
+	if err != nil {
+		return err
+	}
+
+	rawRows := r.rawResults["rows"].([]interface{})
+	rows := r.ToTable()
+	out := reflect.MakeSlice(slice.Type(), 0, len(rows))
+	for i, row := range rows {
+		elem := reflect.New(structType).Elem()
+		for _, f := range fields {
+			if f.column >= len(row) {
+				continue
+			}
+			value := row[f.column]
+			if f.override != nil {
+				var raw interface{}
+				if rawRow := rawRows[i].([]interface{}); f.column < len(rawRow) {
+					raw = rawRow[f.column]
+				}
+				if value, err = f.override(raw); err != nil {
+					return fmt.Errorf("scan row %d, column %s: %w", i, f.name, err)
+				}
+			}
+			if err := assignValue(elem.FieldByIndex(f.index), value); err != nil {
+				return fmt.Errorf("scan row %d, column %s: %w", i, f.name, err)
+			}
+		}
+		if elemType.Kind() == reflect.Ptr {
+			out = reflect.Append(out, elem.Addr())
+		} else {
+			out = reflect.Append(out, elem)
+		}
+	}
+
+	slice.Set(out)
+	return nil
+}
+
+type scanField struct {
+	index    []int
+	name     string
+	column   int
+	override converter
+}
+
+// structField is the column binding of a struct field as declared by its tag.
+type structField struct {
+	index    []int
+	goName   string
+	tagName  string
+	override converter
+}
+
+type structFieldsEntry struct {
+	fields []structField
+	err    error
+}
+
+// structFieldsCache holds the parsed fields of every struct type scanned into,
+// keyed by reflect.Type, so that tags are only parsed once per type.
+var structFieldsCache sync.Map
+
+func cachedStructFields(structType reflect.Type) ([]structField, error) {
+	if entry, ok := structFieldsCache.Load(structType); ok {
+		e := entry.(structFieldsEntry)
+		return e.fields, e.err
+	}
+
+	fields, err := parseStructFields(structType)
+	structFieldsCache.Store(structType, structFieldsEntry{fields: fields, err: err})
+	return fields, err
+}
+
+func parseStructFields(structType reflect.Type) ([]structField, error) {
+	var fields []structField
+	for i := 0; i < structType.NumField(); i++ {
+		field := structType.Field(i)
+		if field.PkgPath != "" {
+			continue
+		}
+
+		tag := field.Tag.Get("yq")
+		if tag == "-" {
+			continue
+		}
+		name, opts, _ := strings.Cut(tag, ",")
+
+		f := structField{index: field.Index, goName: field.Name, tagName: name}
+		if opts != "" {
+			override, err := tagConverter(opts)
+			if err != nil {
+				return nil, fmt.Errorf("scan: field %s: %w", field.Name, err)
+			}
+			f.override = override
+		}
+		fields = append(fields, f)
+	}
+	return fields, nil
+}
+
+func scanFields(structType reflect.Type, columns []Column) ([]scanField, error) {
+	declared, err := cachedStructFields(structType)
+	if err != nil {
+		return nil, err
+	}
+
+	var fields []scanField
+	for _, field := range declared {
+		column := -1
+		for j, col := range columns {
+			if (field.tagName != "" && col.Name == field.tagName) || (field.tagName == "" && strings.EqualFold(col.Name, field.goName)) {
+				column = j
+				break
+			}
+		}
+		if column < 0 {
+			continue
+		}
+		fields = append(fields, scanField{index: field.index, name: columns[column].Name, column: column, override: field.override})
+	}
+	return fields, nil
+}
+
+func tagConverter(option string) (converter, error) {
+	if scale, ok := strings.CutPrefix(option, "scale="); ok {
+		n, err := strconv.ParseInt(scale, 10, 64)
+		if err != nil || n <= 0 {
+			return nil, fmt.Errorf("invalid scale %q", scale)
+		}
+		return scaledDecimalConverter(n), nil
+	}
+
+	switch option {
+	case "decimal":
+		return convertToDecimal, nil
+	case "unixmillis":
+		return unixTimeConverter(time.Millisecond), nil
+	case "unixseconds":
+		return unixTimeConverter(time.Second), nil
+	default:
+		return nil, fmt.Errorf("unknown tag option %q", option)
+	}
+}
+
+func convertToDecimal(value interface{}) (interface{}, error) {
+	switch v := value.(type) {
+	case nil:
+		return nil, nil
+	case string:
+		n, ok := new(big.Rat).SetString(v)
+		if !ok {
+			return value, fmt.Errorf("invalid decimal %q", v)
+		}
+		return n, nil
+	case float64:
+		return new(big.Rat).SetFloat64(v), nil
+	case int64:
+		return new(big.Rat).SetInt64(v), nil
+	default:
+		return value, fmt.Errorf("cannot convert %T to decimal", value)
+	}
+}
+
+func scaledDecimalConverter(scale int64) converter {
+	return func(value interface{}) (interface{}, error) {
+		d, err := convertToDecimal(value)
+		if err != nil || d == nil {
+			return d, err
+		}
+		return new(big.Rat).Quo(d.(*big.Rat), new(big.Rat).SetInt64(scale)), nil
+	}
+}
+
+// ScaledDecimalHook returns a column hook, for use with AddColumnHook, that
+// turns integers holding a fixed-point amount, e.g. money in cents, into a
+// *big.Rat divided by scale. Values that aren't numbers are left unchanged.
+// It fails if scale isn't positive.
+func ScaledDecimalHook(scale int64) (func(interface{}) interface{}, error) {
+	if scale <= 0 {
+		return nil, fmt.Errorf("invalid scale %d", scale)
+	}
+	convert := scaledDecimalConverter(scale)
+	return func(value interface{}) interface{} {
+		converted, err := convert(value)
+		if err != nil {
+			return value
+		}
+		return converted
+	}, nil
+}
+
+func unixTimeConverter(unit time.Duration) converter {
+	return func(value interface{}) (interface{}, error) {
+		var n int64
+		switch v := value.(type) {
+		case nil:
+			return nil, nil
+		case float64:
+			n = int64(v)
+		case string:
+			var err error
+			if n, err = strconv.ParseInt(v, 10, 64); err != nil {
+				return value, err
+			}
+		default:
+			return value, fmt.Errorf("cannot convert %T to time", value)
+		}
+		return time.Unix(0, 0).Add(time.Duration(n) * unit).UTC(), nil
+	}
+}
+
+func assignValue(field reflect.Value, value interface{}) error {
+	if value == nil {
+		field.Set(reflect.Zero(field.Type()))
+		return nil
+	}
+
+	v := reflect.ValueOf(value)
+	if v.Type().AssignableTo(field.Type()) {
+		field.Set(v)
+		return nil
+	}
+
+	if field.Kind() == reflect.Ptr {
+		ptr := reflect.New(field.Type().Elem())
+		if err := assignValue(ptr.Elem(), value); err != nil {
+			return err
+		}
+		field.Set(ptr)
+		return nil
+	}
+
+	if rat, ok := value.(*big.Rat); ok {
+		switch field.Kind() {
+		case reflect.Float32, reflect.Float64:
+			f, _ := rat.Float64()
+			field.SetFloat(f)
+			return nil
+		case reflect.String:
+			field.SetString(rat.RatString())
+			return nil
+		}
+	}
+
+	if isNumberKind(v.Kind()) && isNumberKind(field.Kind()) {
+		field.Set(v.Convert(field.Type()))
+		return nil
+	}
+
+	return fmt.Errorf("cannot assign %T to %s", value, field.Type())
+}
+
+func isNumberKind(k reflect.Kind) bool {
+	switch k {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return true
+	default:
+		return false
+	}
+}
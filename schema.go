@@ -0,0 +1,83 @@
+package yq
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+// JSONSchema returns a JSON Schema describing a row of the result set as an
+// object keyed by column name. YQL types map to JSON Schema types as follows:
+//
+//	Bool                               boolean
+//	Int8..Int64, Uint8..Uint64         integer
+//	Float, Double, Decimal             number
+//	String, Utf8, Uuid, Json, Yson,
+//	JsonDocument                       string
+//	Date                               string, format "date"
+//	Datetime, Timestamp                string, format "date-time"
+//	List<T>                            array of T
+//	Struct<...>                        object with a property per field
+//	Optional<T>                        T or null; the column isn't required
+//
+// Other types are described by an empty schema, which accepts any value.
+func (r *Results) JSONSchema() ([]byte, error) {
+	properties := make(map[string]interface{})
+	required := []string{}
+	for _, col := range r.Columns() {
+		schema, optional := jsonSchemaForType(col.Type)
+		properties[col.Name] = schema
+		if !optional {
+			required = append(required, col.Name)
+		}
+	}
+
+	return json.Marshal(map[string]interface{}{
+		"$schema":    "https://json-schema.org/draft/2020-12/schema",
+		"type":       "object",
+		"properties": properties,
+		"required":   required,
+	})
+}
+
+func jsonSchemaForType(columnType string) (schema map[string]interface{}, optional bool) {
+	name, args, _ := parseTypeArgs(columnType)
+	switch {
+	case name == "Optional" && len(args) == 1:
+		inner, _ := jsonSchemaForType(args[0])
+		if t, ok := inner["type"].(string); ok {
+			inner["type"] = []string{t, "null"}
+		}
+		return inner, true
+	case name == "List" && len(args) == 1:
+		items, _ := jsonSchemaForType(args[0])
+		return map[string]interface{}{"type": "array", "items": items}, false
+	case name == "Struct":
+		properties := make(map[string]interface{}, len(args))
+		for _, field := range args {
+			fieldName, fieldType := splitStructField(field)
+			properties[fieldName], _ = jsonSchemaForType(fieldType)
+		}
+		return map[string]interface{}{"type": "object", "properties": properties}, false
+	case strings.HasPrefix(name, "Decimal"):
+		return map[string]interface{}{"type": "number"}, false
+	}
+
+	switch columnType {
+	case "Bool":
+		return map[string]interface{}{"type": "boolean"}, false
+	case "Int8", "Int16", "Int32", "Int64", "Uint8", "Uint16", "Uint32", "Uint64":
+		return map[string]interface{}{"type": "integer"}, false
+	case "Float", "Double":
+		return map[string]interface{}{"type": "number"}, false
+	case "String", "Utf8", "Uuid", "Json", "Yson":
+		return map[string]interface{}{"type": "string"}, false
+	case "Date":
+		return map[string]interface{}{"type": "string", "format": "date"}, false
+	case "Datetime", "Timestamp":
+		return map[string]interface{}{"type": "string", "format": "date-time"}, false
+	case "Null", "Void":
+		return map[string]interface{}{"type": "null"}, false
+	default:
+		return map[string]interface{}{}, false
+	}
+}
@@ -0,0 +1,158 @@
+package yq
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+// ExportFormat is an output format for exported result sets.
+type ExportFormat string
+
+const (
+	ExportCSV   ExportFormat = "csv"
+	ExportJSONL ExportFormat = "jsonl"
+)
+
+func newSink(format ExportFormat, w io.Writer) (RowSink, error) {
+	switch format {
+	case ExportCSV:
+		return NewCSVSink(w), nil
+	case ExportJSONL:
+		return NewJSONLSink(w), nil
+	default:
+		return nil, fmt.Errorf("unsupported export format %q", format)
+	}
+}
+
+// RowSink consumes the rows of a result set, e.g. to export them.
+type RowSink interface {
+	WriteHeader(columns []Column) error
+	WriteRow(row []interface{}) error
+	Close() error
+}
+
+// PipeResultSet streams the converted rows of a result set into sink page by
+// page, and closes the sink once all rows were written.
+func (c *Client) PipeResultSet(ctx context.Context, queryID string, resultSetIndex int, sink RowSink) error {
+	it := c.IterateResultSet(ctx, queryID, resultSetIndex)
+	headerWritten := false
+	for it.Next() {
+		if !headerWritten {
+			if err := sink.WriteHeader(it.Columns()); err != nil {
+				return err
+			}
+			headerWritten = true
+		}
+		if err := sink.WriteRow(it.Row()); err != nil {
+			return err
+		}
+	}
+	if err := it.Err(); err != nil {
+		return err
+	}
+
+	if !headerWritten {
+		if err := sink.WriteHeader(it.Columns()); err != nil {
+			return err
+		}
+	}
+	return sink.Close()
+}
+
+// RunQueryToWriter creates a query, waits for it to succeed and streams its
+// first result set to w in the given format, page by page. Other result sets
+// are not exported; use PipeResultSet for those.
+func (c *Client) RunQueryToWriter(ctx context.Context, req CreateQueryRequest, format ExportFormat, w io.Writer) error {
+	sink, err := newSink(format, w)
+	if err != nil {
+		return err
+	}
+
+	queryID, resultSetCount, err := c.RunQuery(ctx, req, "", 0, false)
+	if err != nil {
+		return err
+	}
+	if resultSetCount == 0 {
+		return fmt.Errorf("query %s returned no result sets", queryID)
+	}
+
+	return c.PipeResultSet(ctx, queryID, 0, sink)
+}
+
+// ResultSetCSVReader returns a reader producing the result set as CSV with a
+// header line. Pages are fetched lazily as the reader is consumed, so the
+// whole set is never buffered. Close the reader to stop fetching early.
+func (c *Client) ResultSetCSVReader(ctx context.Context, queryID string, resultSetIndex int) io.ReadCloser {
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(c.PipeResultSet(ctx, queryID, resultSetIndex, NewCSVSink(pw)))
+	}()
+	return pr
+}
+
+// CSVSink writes rows as CSV with a header line. Closing it flushes the
+// output but doesn't close the underlying writer.
+type CSVSink struct {
+	w      *csv.Writer
+	record []string
+}
+
+// NewCSVSink returns a CSVSink writing to w.
+func NewCSVSink(w io.Writer) *CSVSink {
+	return &CSVSink{w: csv.NewWriter(w)}
+}
+
+func (s *CSVSink) WriteHeader(columns []Column) error {
+	header := make([]string, len(columns))
+	for i, col := range columns {
+		header[i] = col.Name
+	}
+	return s.w.Write(header)
+}
+
+func (s *CSVSink) WriteRow(row []interface{}) error {
+	s.record = s.record[:0]
+	for _, v := range row {
+		s.record = append(s.record, formatCell(v))
+	}
+	return s.w.Write(s.record)
+}
+
+func (s *CSVSink) Close() error {
+	s.w.Flush()
+	return s.w.Error()
+}
+
+// JSONLSink writes each row as a JSON object keyed by column name on its own
+// line. Closing it doesn't close the underlying writer.
+type JSONLSink struct {
+	enc     *json.Encoder
+	columns []Column
+}
+
+// NewJSONLSink returns a JSONLSink writing to w.
+func NewJSONLSink(w io.Writer) *JSONLSink {
+	return &JSONLSink{enc: json.NewEncoder(w)}
+}
+
+func (s *JSONLSink) WriteHeader(columns []Column) error {
+	s.columns = columns
+	return nil
+}
+
+func (s *JSONLSink) WriteRow(row []interface{}) error {
+	obj := make(map[string]interface{}, len(s.columns))
+	for i, col := range s.columns {
+		if i < len(row) {
+			obj[col.Name] = jsonValue(row[i])
+		}
+	}
+	return s.enc.Encode(obj)
+}
+
+func (s *JSONLSink) Close() error {
+	return nil
+}
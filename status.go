@@ -0,0 +1,19 @@
+package yq
+
+// QueryStatus is the execution status of a query.
+type QueryStatus string
+
+const (
+	StatusPending   QueryStatus = "PENDING"
+	StatusRunning   QueryStatus = "RUNNING"
+	StatusCompleted QueryStatus = "COMPLETED"
+	StatusFailed    QueryStatus = "FAILED"
+	StatusAborted   QueryStatus = "ABORTED"
+)
+
+// IsTerminal reports whether a query with this status has finished, i.e. is
+// neither pending nor running. Statuses unknown to the client count as
+// terminal; the empty status doesn't.
+func (s QueryStatus) IsTerminal() bool {
+	return s != "" && s != StatusPending && s != StatusRunning
+}
@@ -0,0 +1,205 @@
+package yq
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+const resultSetPageSize = 1000
+
+// defaultPageLimit is the page size the API uses when no limit is given.
+const defaultPageLimit = 100
+
+// Row is a converted result set row.
+type Row []interface{}
+
+// ResultSetIterator iterates over the converted rows of a result set, fetching
+// pages on demand instead of buffering the whole set.
+type ResultSetIterator struct {
+	client         *Client
+	ctx            context.Context
+	queryID        string
+	resultSetIndex int
+
+	columns []Column
+	page    [][]interface{}
+	pos     int
+	start   int
+	offset  int
+	done    bool
+	row     Row
+	err     error
+}
+
+// IterateResultSet returns an iterator over the rows of a query result set.
+func (c *Client) IterateResultSet(ctx context.Context, queryID string, resultSetIndex int) *ResultSetIterator {
+	return c.IterateResultSetFrom(ctx, queryID, resultSetIndex, 0)
+}
+
+// IterateResultSetFrom is like IterateResultSet but starts at the given row
+// offset, e.g. one previously checkpointed via Offset.
+func (c *Client) IterateResultSetFrom(ctx context.Context, queryID string, resultSetIndex int, offset int) *ResultSetIterator {
+	return &ResultSetIterator{
+		client:         c,
+		ctx:            ctx,
+		queryID:        queryID,
+		resultSetIndex: resultSetIndex,
+		offset:         offset,
+	}
+}
+
+// Next advances the iterator to the next row. It returns false when the result
+// set is exhausted or an error occurred; check Err to tell the two apart.
+func (it *ResultSetIterator) Next() bool {
+	if it.err != nil {
+		return false
+	}
+	for it.pos >= len(it.page) {
+		if it.done || !it.fetch() {
+			return false
+		}
+	}
+	it.row = it.page[it.pos]
+	it.pos++
+	return true
+}
+
+func (it *ResultSetIterator) fetch() bool {
+	page, err := it.client.GetResultSetPage(it.ctx, it.queryID, it.resultSetIndex, it.offset, resultSetPageSize)
+	if err != nil {
+		it.err = err
+		return false
+	}
+
+	if it.columns == nil {
+		it.columns = page.Columns()
+	}
+
+	it.page = page.Results(it.client.resultsOptions()...).ToTable()
+	it.pos = 0
+	it.start = it.offset
+	it.offset += len(page.Rows)
+	it.done = !page.HasMore || len(page.Rows) == 0
+	return true
+}
+
+// Row returns the current row.
+func (it *ResultSetIterator) Row() Row {
+	return it.row
+}
+
+// Offset returns the position of the current row within the result set.
+// After processing the row, a consumer may record Offset()+1 as a checkpoint
+// and later resume reading from it.
+func (it *ResultSetIterator) Offset() int {
+	return it.start + it.pos - 1
+}
+
+// PageOffset returns the offset of the page the current row was fetched in.
+func (it *ResultSetIterator) PageOffset() int {
+	return it.start
+}
+
+// Columns returns the result set schema. It is available after the first call to Next.
+func (it *ResultSetIterator) Columns() []Column {
+	return it.columns
+}
+
+// Err returns the error that stopped the iteration, if any.
+func (it *ResultSetIterator) Err() error {
+	return it.err
+}
+
+// StreamResultSetBatches streams the converted rows of a result set to fn in
+// batches of exactly batchSize rows, except for the last one which may be smaller.
+func (c *Client) StreamResultSetBatches(ctx context.Context, queryID string, resultSetIndex int, batchSize int, fn func([]Row) error) error {
+	if batchSize <= 0 {
+		return fmt.Errorf("invalid batch size %d", batchSize)
+	}
+
+	it := c.IterateResultSet(ctx, queryID, resultSetIndex)
+	batch := make([]Row, 0, batchSize)
+	for it.Next() {
+		batch = append(batch, it.Row())
+		if len(batch) == batchSize {
+			if err := fn(batch); err != nil {
+				return err
+			}
+			batch = make([]Row, 0, batchSize)
+		}
+	}
+	if err := it.Err(); err != nil {
+		return err
+	}
+
+	if len(batch) > 0 {
+		return fn(batch)
+	}
+	return nil
+}
+
+// FollowResultSet passes the converted rows of a result set to fn as they
+// become available while the query is still running, and returns once the
+// query has completed and all rows were delivered. Every row is delivered
+// exactly once: the status is checked before each drain, so the last drain
+// always happens after completion.
+func (c *Client) FollowResultSet(ctx context.Context, queryID string, resultSetIndex int, fn func(Row) error) error {
+	startTime := time.Now()
+	delay := c.nextPollDelay(0, 0)
+	offset := 0
+	failures := 0
+
+	for {
+		status, err := c.pollStatus(ctx, queryID, &failures)
+		if err != nil {
+			return err
+		}
+		if status != "" {
+			terminal := status.IsTerminal()
+			if terminal && status != StatusCompleted {
+				return fmt.Errorf("query %s finished with status %s", queryID, status)
+			}
+			if err := c.drainResultSet(ctx, queryID, resultSetIndex, &offset, terminal, fn); err != nil {
+				return err
+			}
+			if terminal {
+				return nil
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(delay):
+			delay = c.nextPollDelay(time.Since(startTime), delay)
+		}
+	}
+}
+
+// drainResultSet passes the rows from *offset onwards that are currently
+// readable to fn, advancing *offset.
+func (c *Client) drainResultSet(ctx context.Context, queryID string, resultSetIndex int, offset *int, terminal bool, fn func(Row) error) error {
+	for {
+		page, err := c.GetResultSetPage(ctx, queryID, resultSetIndex, *offset, resultSetPageSize)
+		if err != nil {
+			if !terminal && ctx.Err() == nil && isTransientError(err) {
+				// Results may not be readable until the query has made
+				// progress; try again at the next poll.
+				return nil
+			}
+			return err
+		}
+
+		for _, row := range page.Results(c.resultsOptions()...).ToTable() {
+			if err := fn(row); err != nil {
+				return err
+			}
+		}
+		*offset += len(page.Rows)
+
+		if !page.HasMore || len(page.Rows) == 0 {
+			return nil
+		}
+	}
+}
@@ -0,0 +1,97 @@
+package yq
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// WriteTable renders the converted results as a plain-text table. Numeric
+// columns are right-aligned, everything else is left-aligned, and times are
+// formatted as RFC 3339. If maxColumnWidth is positive, longer cells are cut
+// to that many characters.
+func (r *Results) WriteTable(w io.Writer, maxColumnWidth int) error {
+	columns := r.Columns()
+	rows := r.ToTable()
+
+	cells := make([][]string, len(rows)+1)
+	cells[0] = make([]string, len(columns))
+	for j, col := range columns {
+		cells[0][j] = truncateCell(col.Name, maxColumnWidth)
+	}
+	for i, row := range rows {
+		cells[i+1] = make([]string, len(columns))
+		for j := range columns {
+			if j < len(row) {
+				cells[i+1][j] = truncateCell(formatCell(row[j]), maxColumnWidth)
+			}
+		}
+	}
+
+	widths := make([]int, len(columns))
+	for _, line := range cells {
+		for j, cell := range line {
+			if n := utf8.RuneCountInString(cell); n > widths[j] {
+				widths[j] = n
+			}
+		}
+	}
+
+	var sb strings.Builder
+	for _, line := range cells {
+		for j, cell := range line {
+			if j > 0 {
+				sb.WriteString("  ")
+			}
+			pad := strings.Repeat(" ", widths[j]-utf8.RuneCountInString(cell))
+			if isNumericType(columns[j].Type) {
+				sb.WriteString(pad + cell)
+			} else if j < len(line)-1 {
+				sb.WriteString(cell + pad)
+			} else {
+				sb.WriteString(cell)
+			}
+		}
+		sb.WriteString("\n")
+	}
+
+	_, err := io.WriteString(w, sb.String())
+	return err
+}
+
+func formatCell(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return ""
+	case time.Time:
+		return v.Format(time.RFC3339)
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	default:
+		return fmt.Sprintf("%v", v)
+	}
+}
+
+func truncateCell(s string, maxWidth int) string {
+	if maxWidth <= 0 || utf8.RuneCountInString(s) <= maxWidth {
+		return s
+	}
+	runes := []rune(s)
+	if maxWidth == 1 {
+		return string(runes[:1])
+	}
+	return string(runes[:maxWidth-1]) + "…"
+}
+
+func isNumericType(columnType string) bool {
+	switch columnType {
+	case "Int8", "Int16", "Int32", "Int64", "Uint8", "Uint16", "Uint32", "Uint64", "Float", "Double",
+		"pgint2", "pgint4", "pgint8", "pgoid", "pgfloat4", "pgfloat8", "pgnumeric":
+		return true
+	default:
+		return strings.HasPrefix(columnType, "Decimal")
+	}
+}
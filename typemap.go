@@ -0,0 +1,180 @@
+package yq
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"math/big"
+	"strconv"
+	"time"
+)
+
+// GoType selects the Go representation of a YQL type in a TypeMapping.
+type GoType int
+
+const (
+	// GoDefault keeps the built-in conversion of the type.
+	GoDefault GoType = iota
+	// GoRaw keeps the raw value as decoded from the API response.
+	GoRaw
+	// GoString converts values to string.
+	GoString
+	// GoInt64 converts values to int64.
+	GoInt64
+	// GoUint64 converts values to uint64.
+	GoUint64
+	// GoFloat64 converts values to float64.
+	GoFloat64
+	// GoBigInt converts values to *big.Int.
+	GoBigInt
+)
+
+// TypeMapping maps column types, e.g. "Uint64", to the Go representation
+// their values are converted to. Types that are not listed, or are mapped to
+// GoDefault, use the built-in conversion. The mapping applies to whole columns,
+// not to fields nested in containers.
+type TypeMapping map[string]GoType
+
+// WithTypeMapping overrides the Go representation of the given column types.
+func WithTypeMapping(mapping TypeMapping) ResultsOption {
+	return func(r *Results) {
+		r.typeMapping = mapping
+	}
+}
+
+// mappedConverter converts values with base and then to the Go type t.
+func mappedConverter(base converter, t GoType) converter {
+	switch t {
+	case GoDefault:
+		return base
+	case GoRaw:
+		return convertIdentity
+	}
+
+	return func(value interface{}) (interface{}, error) {
+		converted, err := base(value)
+		if err != nil || converted == nil {
+			return converted, err
+		}
+		switch t {
+		case GoString:
+			return toString(converted), nil
+		case GoInt64:
+			return toInt64(converted)
+		case GoUint64:
+			return toUint64(converted)
+		case GoFloat64:
+			return toFloat64(converted)
+		case GoBigInt:
+			return toBigInt(converted)
+		}
+		return converted, nil
+	}
+}
+
+func toString(value interface{}) string {
+	switch v := value.(type) {
+	case string:
+		return v
+	case []byte:
+		return string(v)
+	case float64:
+		// Integers beyond 2^53, e.g. large Uint64 values, are printed exactly
+		// rather than as their shortest round-tripping form.
+		if v == math.Trunc(v) && !math.IsInf(v, 0) {
+			return strconv.FormatFloat(v, 'f', 0, 64)
+		}
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case time.Time:
+		return v.Format(time.RFC3339Nano)
+	case *big.Rat:
+		return v.RatString()
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+func toInt64(value interface{}) (interface{}, error) {
+	switch v := value.(type) {
+	case int64:
+		return v, nil
+	case float64:
+		if v != math.Trunc(v) || v < math.MinInt64 || v >= math.MaxInt64 {
+			return nil, fmt.Errorf("%v is not an int64", v)
+		}
+		return int64(v), nil
+	case string:
+		return strconv.ParseInt(v, 10, 64)
+	case json.Number:
+		return v.Int64()
+	}
+	return value, nil
+}
+
+func toUint64(value interface{}) (interface{}, error) {
+	switch v := value.(type) {
+	case int64:
+		if v < 0 {
+			return nil, fmt.Errorf("%d is not a uint64", v)
+		}
+		return uint64(v), nil
+	case float64:
+		if v != math.Trunc(v) || v < 0 || v >= math.MaxUint64 {
+			return nil, fmt.Errorf("%v is not a uint64", v)
+		}
+		return uint64(v), nil
+	case string:
+		return strconv.ParseUint(v, 10, 64)
+	case json.Number:
+		return strconv.ParseUint(v.String(), 10, 64)
+	}
+	return value, nil
+}
+
+func toFloat64(value interface{}) (interface{}, error) {
+	switch v := value.(type) {
+	case float64:
+		return v, nil
+	case int64:
+		return float64(v), nil
+	case string:
+		return strconv.ParseFloat(v, 64)
+	case json.Number:
+		return v.Float64()
+	case *big.Rat:
+		f, _ := v.Float64()
+		return f, nil
+	}
+	return value, nil
+}
+
+func toBigInt(value interface{}) (interface{}, error) {
+	switch v := value.(type) {
+	case int64:
+		return big.NewInt(v), nil
+	case float64:
+		if v != math.Trunc(v) || math.IsInf(v, 0) {
+			return nil, fmt.Errorf("%v is not an integer", v)
+		}
+		i, _ := big.NewFloat(v).Int(nil)
+		return i, nil
+	case string:
+		i, ok := new(big.Int).SetString(v, 10)
+		if !ok {
+			return nil, fmt.Errorf("%q is not an integer", v)
+		}
+		return i, nil
+	case json.Number:
+		i, ok := new(big.Int).SetString(v.String(), 10)
+		if !ok {
+			return nil, fmt.Errorf("%q is not an integer", v)
+		}
+		return i, nil
+	case *big.Rat:
+		if !v.IsInt() {
+			return nil, fmt.Errorf("%s is not an integer", v.RatString())
+		}
+		return new(big.Int).Set(v.Num()), nil
+	}
+	return value, nil
+}
@@ -0,0 +1,72 @@
+package yq
+
+import "strings"
+
+// parseTypeArgs splits a parameterized YQL type string such as
+// "Struct<a:Int32,b:String>" into its name and top-level arguments.
+// ok is false for types without arguments.
+func parseTypeArgs(typeString string) (name string, args []string, ok bool) {
+	open := strings.IndexByte(typeString, '<')
+	if open < 0 || !strings.HasSuffix(typeString, ">") {
+		return typeString, nil, false
+	}
+	name = typeString[:open]
+	inner := typeString[open+1 : len(typeString)-1]
+
+	depth := 0
+	var quote byte
+	start := 0
+	for i := 0; i < len(inner); i++ {
+		c := inner[i]
+		switch {
+		case quote != 0:
+			if c == '\\' {
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '\'' || c == '"':
+			quote = c
+		case c == '<':
+			depth++
+		case c == '>':
+			depth--
+		case c == ',' && depth == 0:
+			args = append(args, strings.TrimSpace(inner[start:i]))
+			start = i + 1
+		}
+	}
+	if rest := strings.TrimSpace(inner[start:]); rest != "" {
+		args = append(args, rest)
+	}
+	return name, args, true
+}
+
+// splitStructField splits a struct member such as "'a':Int32" into its
+// unquoted name and type.
+func splitStructField(field string) (name, fieldType string) {
+	var quote byte
+	for i := 0; i < len(field); i++ {
+		c := field[i]
+		switch {
+		case quote != 0:
+			if c == '\\' {
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '\'' || c == '"':
+			quote = c
+		case c == ':':
+			return unquote(strings.TrimSpace(field[:i])), strings.TrimSpace(field[i+1:])
+		}
+	}
+	return unquote(strings.TrimSpace(field)), ""
+}
+
+func unquote(s string) string {
+	if len(s) >= 2 && (s[0] == '\'' || s[0] == '"') && s[len(s)-1] == s[0] {
+		return s[1 : len(s)-1]
+	}
+	return s
+}